@@ -1 +1 @@
-GOOS=linux GOARCH=amd64 CGO_ENABLED=0 go build -o terraform/bin/main cmd/main.go
\ No newline at end of file
+GOOS=linux GOARCH=amd64 CGO_ENABLED=0 go build -o terraform/bin/main ./cmd
\ No newline at end of file
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Sender is the part of the Telegram Bot API the bot talks to. It is
+// satisfied by *tgbotapi.BotAPI and can be replaced by a fake in tests.
+type Sender interface {
+	Send(c tgbotapi.Chattable) (tgbotapi.Message, error)
+	Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error)
+}
+
+// App holds everything needed to process a single update. All run modes
+// (Lambda, polling and webhook) feed their updates into ProcessUpdate.
+type App struct {
+	bot      Sender
+	store    Store
+	config   *Config
+	commands []*Command
+	// middlewares run around every command handler
+	middlewares []Middleware
+	now         func() time.Time
+}
+
+func newApp(bot Sender, store Store, config *Config, now func() time.Time) *App {
+	return &App{
+		bot:         bot,
+		store:       store,
+		config:      config,
+		commands:    newCommands(),
+		middlewares: defaultMiddlewares(),
+		now:         now,
+	}
+}
+
+// processedUpdateTTL is how long an update id is remembered. It outlives the
+// 24 hour retention of the Kinesis stream.
+const processedUpdateTTL = 48 * time.Hour
+
+// processingLease is how long an attempt to process an update keeps other
+// attempts away. It outlasts the Lambda timeout and the webhook timeout of
+// Telegram, so an attempt that crashed or timed out lets the retry through.
+const processingLease = 2 * time.Minute
+
+// errUpdateInProgress is returned while another attempt holds the lease of
+// the update. It is retriable, so the update is delivered again later.
+var errUpdateInProgress = errors.New("update is being processed")
+
+// isRetriable reports whether processing may succeed when repeated, e.g.
+// after DynamoDB throttling or a network failure.
+func isRetriable(err error) bool {
+	if errors.Is(err, errUpdateInProgress) {
+		return true
+	}
+	var requestFailure awserr.RequestFailure
+	if errors.As(err, &requestFailure) && requestFailure.StatusCode() >= 500 {
+		return true
+	}
+	return request.IsErrorRetryable(err) || request.IsErrorThrottle(err)
+}
+
+// ProcessUpdate handles one Telegram update. Errors are returned so the caller
+// can log them; retriable errors are not reported to the user because the
+// caller is expected to process the update again.
+func (a *App) ProcessUpdate(ctx context.Context, update tgbotapi.Update) error {
+	// updates are delivered at least once, so replays are skipped
+	status, err := a.store.LeaseUpdate(update.UpdateID, a.now(), processingLease)
+	if err != nil {
+		return err
+	}
+	switch status {
+	case UpdateProcessed:
+		log.Printf("skipping update %d: already processed\n", update.UpdateID)
+		return nil
+	case UpdateInProgress:
+		return fmt.Errorf("update %d: %w", update.UpdateID, errUpdateInProgress)
+	}
+
+	err = a.processUpdate(update)
+	if err != nil && isRetriable(err) {
+		// let the retry process the update again
+		if err := a.store.UnmarkUpdateProcessed(update.UpdateID); err != nil {
+			log.Printf("failed to unmark update %d: %v\n", update.UpdateID, err)
+		}
+		return err
+	}
+	// other errors were reported to the user, a retry would not help
+	a.markUpdateProcessed(update.UpdateID)
+	return err
+}
+
+// markUpdateProcessed turns the lease of the handled update into a ledger
+// entry. A failure is only logged: the replies were sent, and the lease
+// still keeps quick replays away.
+func (a *App) markUpdateProcessed(updateID int) {
+	if err := a.store.MarkUpdateProcessed(updateID, a.now(), processedUpdateTTL); err != nil {
+		log.Printf("failed to mark update %d: %v\n", updateID, err)
+	}
+}
+
+func (a *App) processUpdate(update tgbotapi.Update) error {
+	var chatID int64
+	var err error
+	switch {
+	case update.CallbackQuery != nil:
+		if update.CallbackQuery.Message == nil {
+			// buttons of inline mode messages are not used by the bot
+			a.bot.Request(tgbotapi.NewCallback(update.CallbackQuery.ID, ""))
+			return nil
+		}
+		chatID = update.CallbackQuery.Message.Chat.ID
+		err = a.handleCallback(update.CallbackQuery)
+	case update.Message != nil:
+		// send greeting message for a new user
+		if update.Message.NewChatMembers != nil {
+			for _, member := range update.Message.NewChatMembers {
+				greetingMessage := "Hello, " + member.FirstName + "!\n" +
+					"Please register with /register <username> and /team <team>"
+				msg := tgbotapi.NewMessage(update.Message.Chat.ID, greetingMessage)
+				a.bot.Send(msg)
+			}
+			return nil
+		}
+
+		chatID = update.Message.Chat.ID
+		if update.Message.IsCommand() {
+			err = a.handleCommand(update.Message)
+		} else {
+			err = a.continueConversation(update.Message)
+		}
+	default:
+		return nil
+	}
+	if err != nil {
+		if isRetriable(err) {
+			return err
+		}
+		msg := tgbotapi.NewMessage(chatID, "Something went wrong. Error: "+err.Error())
+		msg.ReplyMarkup = tgbotapi.NewRemoveKeyboard(true)
+		a.bot.Send(msg)
+		return err
+	}
+	return nil
+}
+
+func (a *App) handleCommand(message *tgbotapi.Message) error {
+	command := findCommand(a.commands, message.Command())
+	if command == nil {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "I don't know that command")
+		a.bot.Send(msg)
+		return nil
+	}
+
+	// the requirements of the command are checked before a dialog starts
+	return a.handle(func(a *App, req *Request) error {
+		return a.startCommand(req, message)
+	})(a, newRequest(command, message))
+}
+
+func newRequest(command *Command, message *tgbotapi.Message) *Request {
+	return &Request{
+		FromID:   message.From.ID,
+		ChatID:   message.Chat.ID,
+		ChatType: message.Chat.Type,
+		Command:  command,
+		Date:     int64(message.Date),
+	}
+}
+
+// handle wraps the handler with the middlewares of the app
+func (a *App) handle(handler HandlerFunc) HandlerFunc {
+	return chain(handler, a.middlewares...)
+}
@@ -0,0 +1,497 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// fakeSender records the texts the bot sends instead of calling Telegram
+type fakeSender struct {
+	sent []string
+}
+
+func (f *fakeSender) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	switch msg := c.(type) {
+	case tgbotapi.MessageConfig:
+		f.sent = append(f.sent, msg.Text)
+	case tgbotapi.EditMessageTextConfig:
+		f.sent = append(f.sent, msg.Text)
+	}
+	return tgbotapi.Message{}, nil
+}
+
+func (f *fakeSender) Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error) {
+	if callback, ok := c.(tgbotapi.CallbackConfig); ok && callback.Text != "" {
+		f.sent = append(f.sent, callback.Text)
+	}
+	return &tgbotapi.APIResponse{Ok: true}, nil
+}
+
+// testBot drives an App backed by the memory store with updates built from
+// plain text, like a user typing to the bot
+type testBot struct {
+	t        *testing.T
+	app      *App
+	sender   *fakeSender
+	store    *memoryStore
+	now      time.Time
+	updateID int
+}
+
+const testAdminSecret = "secret"
+
+func newTestBot(t *testing.T) *testBot {
+	config := defaultConfig()
+	config.AdminSecretHash = calculateHash(testAdminSecret)
+
+	bot := &testBot{
+		t:      t,
+		sender: &fakeSender{},
+		store:  newMemoryStore(),
+		now:    time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+	}
+	bot.app = newApp(bot.sender, bot.store, config, func() time.Time { return bot.now })
+	return bot
+}
+
+func (b *testBot) message(fromID int64, chatType string, text string) tgbotapi.Update {
+	b.updateID++
+	message := &tgbotapi.Message{
+		MessageID: b.updateID,
+		From:      &tgbotapi.User{ID: fromID},
+		Chat:      &tgbotapi.Chat{ID: fromID, Type: chatType},
+		Date:      int(b.now.Unix()),
+		Text:      text,
+	}
+	if strings.HasPrefix(text, "/") {
+		command, _, _ := strings.Cut(text, " ")
+		message.Entities = []tgbotapi.MessageEntity{{Type: "bot_command", Length: len(command)}}
+	}
+	return tgbotapi.Update{UpdateID: b.updateID, Message: message}
+}
+
+// process runs the update and returns the texts the bot sent in response
+func (b *testBot) process(update tgbotapi.Update) []string {
+	b.t.Helper()
+	b.sender.sent = nil
+	if err := b.app.ProcessUpdate(context.Background(), update); err != nil {
+		b.t.Fatalf("failed to process update %d: %v", update.UpdateID, err)
+	}
+	return b.sender.sent
+}
+
+// send types the text in the private chat of the user and expects a single
+// reply starting with want
+func (b *testBot) send(fromID int64, text string, want string) {
+	b.t.Helper()
+	b.expect(b.process(b.message(fromID, "private", text)), text, want)
+}
+
+func (b *testBot) expect(sent []string, text string, want string) {
+	b.t.Helper()
+	if len(sent) != 1 || !strings.HasPrefix(sent[0], want) {
+		b.t.Fatalf("%q: got replies %q, want one starting with %q", text, sent, want)
+	}
+}
+
+// press sends the inline button with the data from the private chat of the
+// user and returns the texts the bot sent in response
+func (b *testBot) press(fromID int64, data string) []string {
+	b.t.Helper()
+	b.updateID++
+	query := &tgbotapi.CallbackQuery{
+		ID:      strconv.Itoa(b.updateID),
+		From:    &tgbotapi.User{ID: fromID},
+		Message: &tgbotapi.Message{MessageID: b.updateID, Chat: &tgbotapi.Chat{ID: fromID, Type: "private"}},
+		Data:    data,
+	}
+	return b.process(tgbotapi.Update{UpdateID: b.updateID, CallbackQuery: query})
+}
+
+// register creates the user and makes them an admin when asked to
+func (b *testBot) register(fromID int64, username string, admin bool) {
+	b.t.Helper()
+	b.send(fromID, "/register "+username, "Nice to meet you, "+username+"!")
+	if admin {
+		b.send(fromID, "/admin "+testAdminSecret, "You are now an admin")
+	}
+}
+
+func TestClaimCode(t *testing.T) {
+	bot := newTestBot(t)
+	bot.register(1, "Alice", true)
+	bot.register(2, "Bob", false)
+	bot.send(1, "/addcode ABC 101", "Code ABC (+1) was added to room 101")
+
+	bot.send(2, "/code ABC", "The game has not started yet")
+	bot.send(1, "/startgame", "The game starts now")
+
+	bot.send(2, "/code XYZ", "Code XYZ does not exist")
+	bot.send(2, "/code ABC", "Congratulations, Bob! You found the code ABC (+1)")
+	bot.send(2, "/code ABC", "You have already found the code ABC")
+	bot.send(1, "/code ABC", "Code ABC was already claimed by Bob")
+}
+
+func TestDialog(t *testing.T) {
+	bot := newTestBot(t)
+	bot.register(1, "Alice", true)
+
+	bot.send(1, "/addcode", "Please provide the code")
+	bot.send(1, "ABC", "Please provide the room")
+	bot.send(1, "101", "Please provide the points")
+	bot.send(1, "x", "Please provide the points as a whole number")
+	bot.send(1, "3", "Please provide the note")
+	bot.send(1, "-", "Code ABC (+3) was added to room 101")
+	bot.send(1, "101", "I don't understand you")
+
+	// inline arguments fill the first steps and the dialog asks for the rest
+	bot.send(1, "/addcode XYZ", "Please provide the room")
+	bot.send(1, "102", "Please provide the points")
+}
+
+func TestInvalidInlineArgument(t *testing.T) {
+	bot := newTestBot(t)
+	bot.register(1, "Alice", true)
+
+	// the problem is the only reply and the dialog waits at the step
+	bot.send(1, "/addcode ABC 101 x", "Please provide the points as a whole number")
+	bot.send(1, "2", "Please provide the note")
+	bot.send(1, "-", "Code ABC (+2) was added to room 101")
+}
+
+// flakyStore fails the first PutCode with a throttling error, like DynamoDB
+// under load
+type flakyStore struct {
+	*memoryStore
+	failed bool
+}
+
+func (s *flakyStore) PutCode(code *DozorCode) error {
+	if !s.failed {
+		s.failed = true
+		return awserr.New(dynamodb.ErrCodeProvisionedThroughputExceededException, "throttled", nil)
+	}
+	return s.memoryStore.PutCode(code)
+}
+
+func TestDialogRetry(t *testing.T) {
+	bot := newTestBot(t)
+	bot.register(1, "Alice", true)
+	bot.app.store = &flakyStore{memoryStore: bot.store}
+
+	bot.send(1, "/addcode", "Please provide the code")
+	bot.send(1, "ABC", "Please provide the room")
+	bot.send(1, "101", "Please provide the points")
+	bot.send(1, "-", "Please provide the note")
+
+	// the last step fails and the update is delivered again
+	update := bot.message(1, "private", "-")
+	if err := bot.app.ProcessUpdate(context.Background(), update); !isRetriable(err) {
+		t.Fatalf("got error %v, want a retriable one", err)
+	}
+	bot.expect(bot.process(update), "-", "Code ABC (+1) was added to room 101")
+	bot.send(1, "-", "I don't understand you")
+}
+
+func TestConversationTimeout(t *testing.T) {
+	bot := newTestBot(t)
+	bot.register(1, "Alice", true)
+
+	bot.send(1, "/addcode", "Please provide the code")
+	bot.now = bot.now.Add(conversationTimeout * time.Second)
+	bot.send(1, "ABC", "Please provide the room")
+
+	bot.now = bot.now.Add((conversationTimeout + 1) * time.Second)
+	bot.send(1, "101", "I don't understand you")
+	bot.send(1, "/codes", "Found: 0 codes")
+}
+
+func TestCancel(t *testing.T) {
+	bot := newTestBot(t)
+	bot.register(1, "Alice", true)
+
+	bot.send(1, "/cancel", "There is nothing to cancel")
+	bot.send(1, "/addcode", "Please provide the code")
+	bot.send(1, "/cancel", "Command /addcode was cancelled")
+	bot.send(1, "ABC", "I don't understand you")
+}
+
+func TestReplayedUpdate(t *testing.T) {
+	bot := newTestBot(t)
+	update := bot.message(1, "private", "/register Alice")
+
+	bot.expect(bot.process(update), "/register Alice", "Nice to meet you, Alice!")
+	if sent := bot.process(update); len(sent) != 0 {
+		t.Fatalf("replayed update: got replies %q, want none", sent)
+	}
+
+	// the ledger forgets the update after its TTL
+	bot.now = bot.now.Add(processedUpdateTTL + time.Second)
+	bot.expect(bot.process(update), "/register Alice", "Nice to meet you, Alice!")
+}
+
+func TestUpdateInProgress(t *testing.T) {
+	bot := newTestBot(t)
+	update := bot.message(1, "private", "/register Alice")
+
+	// another attempt is processing the update
+	if _, err := bot.store.LeaseUpdate(update.UpdateID, bot.now, processingLease); err != nil {
+		t.Fatalf("failed to lease update: %v", err)
+	}
+	err := bot.app.ProcessUpdate(context.Background(), update)
+	if !errors.Is(err, errUpdateInProgress) || !isRetriable(err) {
+		t.Fatalf("got error %v, want a retriable %v", err, errUpdateInProgress)
+	}
+
+	// the lease of an attempt that crashed expires
+	bot.now = bot.now.Add(processingLease + time.Second)
+	bot.expect(bot.process(update), "/register Alice", "Nice to meet you, Alice!")
+}
+
+func TestMiddlewares(t *testing.T) {
+	bot := newTestBot(t)
+
+	bot.send(1, "/code ABC", "Please register first")
+	bot.send(1, "/unknown", "I don't know that command")
+
+	bot.register(1, "Alice", false)
+	bot.send(1, "/addcode ABC 101", "You are not an admin")
+	bot.send(1, "/admin wrong", "You are not an admin")
+
+	bot.send(1, "/admin "+testAdminSecret, "You are now an admin")
+	bot.expect(bot.process(bot.message(1, "group", "/addcode ABC 101")), "/addcode ABC 101",
+		"Please send /addcode in a private chat with me")
+	bot.send(1, "/addcode ABC 101", "Code ABC (+1) was added to room 101")
+
+	// the role is checked again when a dialog ends
+	bot.send(1, "/addcode", "Please provide the code")
+	bot.send(1, "/stopadmin "+testAdminSecret, "You are not an admin anymore")
+	bot.send(1, "XYZ", "Please provide the room")
+	bot.send(1, "102", "Please provide the points")
+	bot.send(1, "-", "Please provide the note")
+	bot.send(1, "-", "You are not an admin")
+}
+
+func TestPauseGame(t *testing.T) {
+	bot := newTestBot(t)
+	bot.register(1, "Alice", true)
+	bot.register(2, "Bob", false)
+	bot.send(1, "/addcode ABC 101", "Code ABC (+1) was added to room 101")
+
+	bot.send(1, "/pausegame", "The game is not running")
+	bot.send(1, "/startgame", "The game starts now and runs until /endgame")
+	bot.send(1, "/startgame", "The game is already scheduled")
+	bot.send(1, "/pausegame", "The game is paused")
+	bot.send(1, "/pausegame", "The game is already paused")
+	bot.send(2, "/code ABC", "The game is paused")
+
+	bot.send(1, "/startgame", "The game is resumed")
+	bot.send(2, "/code ABC", "Congratulations, Bob!")
+}
+
+// standingsSent counts the final standings among the replies
+func standingsSent(sent []string) int {
+	count := 0
+	for _, text := range sent {
+		if strings.HasPrefix(text, "The game is over! Final standings") {
+			count++
+		}
+	}
+	return count
+}
+
+func TestScheduledGameEnd(t *testing.T) {
+	bot := newTestBot(t)
+	bot.register(1, "Alice", true)
+	bot.register(2, "Bob", false)
+	bot.register(3, "Carol", false)
+	bot.send(1, "/addcode ABC 101", "Code ABC (+1) was added to room 101")
+	bot.send(1, "/startgame 12:00 1h", "The game starts now and ends at May 1 13:00 UTC")
+	bot.send(2, "/code ABC", "Congratulations, Bob!")
+
+	// the announcement was cut short after Carol got the standings
+	if _, err := bot.store.MarkStandingsSent(3, bot.now.Unix()); err != nil {
+		t.Fatalf("failed to mark standings sent: %v", err)
+	}
+
+	// the first command after the end is answered, then the rest of the
+	// players get the standings
+	bot.now = bot.now.Add(2 * time.Hour)
+	sent := bot.process(bot.message(2, "private", "/whoami"))
+	if len(sent) != 3 || !strings.HasPrefix(sent[0], "You are Bob") || standingsSent(sent) != 2 {
+		t.Fatalf("first command after the end: got replies %q, want the reply and 2 standings", sent)
+	}
+
+	// the standings are sent only once
+	bot.send(1, "/whoami", "You are Alice")
+	bot.send(2, "/code ABC", "The game is over")
+	bot.send(1, "/endgame", "The game is already over")
+}
+
+func TestStartGameAfterEnd(t *testing.T) {
+	bot := newTestBot(t)
+	bot.register(1, "Alice", true)
+	bot.register(2, "Bob", false)
+	bot.send(1, "/startgame 12:00 1h", "The game starts now")
+
+	// nobody sent a command since the end, the new game announces the old one
+	bot.now = bot.now.Add(2 * time.Hour)
+	sent := bot.process(bot.message(1, "private", "/startgame"))
+	if len(sent) != 3 || standingsSent(sent) != 2 || !strings.HasPrefix(sent[2], "The game starts now") {
+		t.Fatalf("/startgame after the end: got replies %q, want 2 standings and the new game", sent)
+	}
+
+	// the new game gets its own standings
+	sent = bot.process(bot.message(1, "private", "/endgame"))
+	if len(sent) != 3 || standingsSent(sent) != 2 || sent[2] != "The game is over, the final standings were sent to 2 players" {
+		t.Fatalf("/endgame: got replies %q, want 2 standings and the count", sent)
+	}
+}
+
+func TestSeedTeams(t *testing.T) {
+	store := newMemoryStore()
+	if err := seedTeams(store, []string{"A", "B"}); err != nil {
+		t.Fatalf("failed to seed teams: %v", err)
+	}
+	if teams, _ := store.ListTeams(); len(teams) != 2 {
+		t.Fatalf("got %d teams after seeding, want 2", len(teams))
+	}
+
+	// teams removed by admins do not come back on the next start
+	store.DeleteTeam("A")
+	store.DeleteTeam("B")
+	if err := seedTeams(store, []string{"A", "B"}); err != nil {
+		t.Fatalf("failed to seed teams: %v", err)
+	}
+	if teams, _ := store.ListTeams(); len(teams) != 0 {
+		t.Fatalf("got %d teams after seeding again, want none", len(teams))
+	}
+}
+
+func TestUniqueUsernames(t *testing.T) {
+	bot := newTestBot(t)
+	bot.register(1, "Alice", false)
+
+	bot.send(2, "/register Alice", "Username Alice is already taken")
+	bot.send(2, "/register ALICE", "Username ALICE is already taken")
+	bot.send(2, "/register bob", "Nice to meet you, bob!")
+	bot.send(1, "/register Bob", "Username Bob is already taken")
+
+	// the owner may change the case, and a changed username is released
+	bot.send(1, "/register alice", "Nice to meet you, alice!")
+	bot.send(1, "/register Carol", "Nice to meet you, Carol!")
+	bot.send(2, "/register ALICE", "Nice to meet you, ALICE!")
+	bot.send(1, "/register bob", "Nice to meet you, bob!")
+	bot.send(2, "/whoami", "You are ALICE")
+}
+
+func TestTopUnknownFindTime(t *testing.T) {
+	bot := newTestBot(t)
+	bot.register(1, "Alice", true)
+	bot.register(2, "Bob", false)
+	bot.send(1, "/addcode ABC 101", "Code ABC (+1) was added to room 101")
+	bot.send(1, "/addcode XYZ 102", "Code XYZ (+1) was added to room 102")
+	bot.send(1, "/startgame", "The game starts now")
+	bot.send(2, "/code ABC", "Congratulations, Bob!")
+	bot.now = bot.now.Add(time.Minute)
+	bot.send(1, "/code XYZ", "Congratulations, Alice!")
+
+	// a find claimed before its time was recorded is not known to be earlier
+	code := bot.store.codes["ABC"]
+	code.FoundAt = 0
+	bot.store.codes["ABC"] = code
+	bot.send(1, "/top", "1. Alice 1 points (1 codes), last find ")
+}
+
+func TestTeams(t *testing.T) {
+	bot := newTestBot(t)
+	bot.register(1, "Alice", true)
+	bot.register(2, "Bob", false)
+	bot.send(1, "/addteam A", "Team A was added")
+	bot.send(1, "/addteam B 🐺 Wolves", "Team 🐺 Wolves was added")
+
+	bot.send(2, "/team", "Please choose your team")
+	bot.expect(bot.press(2, "team:B"), "team:B", "Welcome to team 🐺 Wolves!")
+	bot.send(2, "/whoami", "You are Bob")
+	bot.expect(bot.press(2, "team:C"), "team:C", "Team C does not exist")
+	bot.send(1, "/team A", "Welcome to team A!")
+
+	bot.send(1, "/renameteam B C", "Team B was renamed to C, 1 players moved")
+	bot.send(2, "/teams", "Teams:\nA: 1 players\n🐺 Wolves (C): 1 players")
+}
+
+func TestTeamTop(t *testing.T) {
+	bot := newTestBot(t)
+	bot.register(1, "Alice", true)
+	bot.register(2, "Bob", false)
+	bot.register(3, "Carol", false)
+	bot.send(1, "/addteam A", "Team A was added")
+	bot.send(1, "/addteam B", "Team B was added")
+	bot.send(2, "/team A", "Welcome to team A!")
+	bot.send(3, "/team B", "Welcome to team B!")
+	bot.send(2, "/teamtop", "1. A 0 (codes: 0 for +0, answers: 0)\n2. B 0")
+
+	bot.send(1, "/addcode ABC 101", "Code ABC (+1) was added to room 101")
+	bot.send(1, "/addcode DEF 102 3", "Code DEF (+3) was added to room 102")
+	bot.send(1, "/addcode XYZ 101", "Code XYZ (+1) was added to room 101")
+	bot.send(1, "/startgame", "The game starts now")
+	bot.send(2, "/code ABC", "Congratulations, Bob!")
+	bot.send(3, "/code DEF", "Congratulations, Carol!")
+	bot.now = bot.now.Add(time.Minute)
+	bot.send(2, "/code XYZ", "Congratulations, Bob!")
+
+	// Bob's team found two codes, Carol's team found the code worth more
+	bot.send(3, "/teamtop", "1. B 3 (codes: 1 for +3, answers: 0), last find May 1 12:00 UTC\n    102: 1\n"+
+		"2. A 2 (codes: 2 for +2, answers: 0), last find May 1 12:01 UTC\n    101: 2\n")
+}
+
+func TestAnswerPuzzle(t *testing.T) {
+	bot := newTestBot(t)
+	bot.register(1, "Alice", true)
+	bot.register(2, "Bob", false)
+	bot.send(1, "/addpuzzle cities", "Puzzle cities was added")
+	bot.send(1, "/addanswer cities kyiv", "Answer kyiv was added to cities")
+	bot.send(1, "/addanswer cities lviv", "Answer lviv was added to cities")
+
+	bot.send(2, "/answer cities kyiv", "The game has not started yet")
+	bot.send(1, "/startgame", "The game starts now")
+	bot.send(2, "/answer towns kyiv", "Please provide a valid puzzle")
+	bot.send(2, "/cancel", "Command /answer was cancelled")
+	bot.send(2, "/answer cities odesa", "Wrong answer")
+	bot.send(2, "/answer cities KYIV!", "Congratulations, Bob! You found the answer kyiv")
+	bot.send(1, "/answer cities kyiv", "Answer kyiv was already found by Bob")
+	bot.send(1, "/answer cities lviv", "Congratulations, Alice! You found the answer lviv\nAll answers of cities were found")
+	bot.send(2, "/answer cities odesa", "All answers of cities were found")
+}
+
+func TestFirstFindBonus(t *testing.T) {
+	bot := newTestBot(t)
+	bot.app.config.FirstFindBonus = 2
+	bot.register(1, "Alice", true)
+	bot.register(2, "Bob", false)
+	bot.send(1, "/addcode BAD 101 -1", "Code BAD (-1) was added to room 101")
+	bot.send(1, "/addcode ABC 101", "Code ABC (+1) was added to room 101")
+	bot.send(1, "/addcode XYZ 101", "Code XYZ (+1) was added to room 101")
+	bot.send(1, "/startgame", "The game starts now")
+
+	// a penalty code does not open the room
+	bot.send(2, "/code BAD", "Oops, Bob! The code BAD is a penalty code (-1)")
+	sent := bot.process(bot.message(1, "private", "/code ABC"))
+	if len(sent) != 1 || sent[0] != "Congratulations, Alice! You found the code ABC (+1)\nYou are the first to find a code in room 101: +2 bonus" {
+		t.Fatalf("/code ABC: got replies %q, want the first find bonus", sent)
+	}
+	sent = bot.process(bot.message(2, "private", "/code XYZ"))
+	if len(sent) != 1 || sent[0] != "Congratulations, Bob! You found the code XYZ (+1)" {
+		t.Fatalf("/code XYZ: got replies %q, want no bonus", sent)
+	}
+	bot.send(2, "/top", "1. Alice 3 points (1 codes)")
+}
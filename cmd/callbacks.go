@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// callbackData builds the data of an inline button handled by the Callback
+// of the command
+func callbackData(command string, arg string) string {
+	return command + ":" + arg
+}
+
+// handleCallback routes a press of an inline button to the Callback of the
+// command named in its data, through the same middlewares as commands.
+func (a *App) handleCallback(query *tgbotapi.CallbackQuery) error {
+	name, arg, _ := strings.Cut(query.Data, ":")
+	command := findCommand(a.commands, name)
+	if command == nil || command.Callback == nil {
+		a.bot.Request(tgbotapi.NewCallback(query.ID, "This button does not work anymore"))
+		return nil
+	}
+
+	return a.handle(command.Callback)(a, &Request{
+		FromID:     query.From.ID,
+		ChatID:     query.Message.Chat.ID,
+		ChatType:   query.Message.Chat.Type,
+		Command:    command,
+		Date:       a.now().Unix(),
+		Arg:        arg,
+		CallbackID: query.ID,
+		MessageID:  query.Message.MessageID,
+	})
+}
+
+// notify tells the user why the request was refused: as a message for
+// commands and as an alert for button presses
+func (a *App) notify(req *Request, text string) {
+	if req.CallbackID != "" {
+		a.bot.Request(tgbotapi.NewCallbackWithAlert(req.CallbackID, text))
+		return
+	}
+	msg := tgbotapi.NewMessage(req.ChatID, text)
+	a.bot.Send(msg)
+}
+
+// answerCallback stops the loading indicator of the pressed button
+func (a *App) answerCallback(req *Request, text string) {
+	a.bot.Request(tgbotapi.NewCallback(req.CallbackID, text))
+}
+
+// editMessage replaces the message with the pressed button
+func (a *App) editMessage(req *Request, text string, markup *tgbotapi.InlineKeyboardMarkup) {
+	edit := tgbotapi.NewEditMessageText(req.ChatID, req.MessageID, text)
+	edit.ReplyMarkup = markup
+	a.bot.Send(edit)
+}
@@ -0,0 +1,538 @@
+package main
+
+import (
+	"log"
+	"strconv"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+type Role int
+
+const (
+	RoleAnyone Role = iota
+	RoleRegistered
+	RoleAdmin
+)
+
+// Request is a command invocation: who sent it, where to reply and the
+// values of its steps. Arg is the value of a single Prompt.
+type Request struct {
+	FromID   int64
+	ChatID   int64
+	ChatType string
+	Command  *Command
+	// Date is the unix time the message was sent or the button was pressed
+	Date int64
+	// User is the profile of the sender loaded by the middlewares, nil
+	// when the sender is not registered
+	User   *UserProfile
+	Arg    string
+	Fields map[string]string
+	// CallbackID and MessageID are set when an inline button was pressed
+	CallbackID string
+	MessageID  int
+}
+
+// Command describes a bot command once; the router, the /what help text and
+// the Telegram command menu are all generated from the list of commands.
+type Command struct {
+	Name        string
+	Aliases     []string
+	Description string
+	Role        Role
+	// Hidden commands are left out of the menu and listed only to admins
+	Hidden bool
+	// ChatTypes limits where the command may be sent, e.g. "private";
+	// empty means anywhere
+	ChatTypes []string
+	// DuringGame commands are refused while the game is not running
+	DuringGame bool
+	// Prompt asks for the argument when it is not given inline after the
+	// command. The next text message of the user is then passed to Handler
+	// as Request.Arg.
+	Prompt string
+	// PromptMarkup returns the reply markup sent along with the prompt
+	PromptMarkup func(a *App) interface{}
+	// Steps replace Prompt for commands that take several inputs
+	Steps   []*Step
+	Handler HandlerFunc
+	// Callback handles presses of inline buttons with the data
+	// "<name>:<arg>", passing arg as Request.Arg. It must answer the callback.
+	Callback HandlerFunc
+}
+
+func newCommands() []*Command {
+	return []*Command{
+		{
+			Name:        "register",
+			Description: "set your username",
+			Role:        RoleAnyone,
+			Steps: []*Step{
+				{
+					Name:     "username",
+					Prompt:   "Please provide your username",
+					Validate: validateUsername,
+				},
+			},
+			Handler: registerUsername,
+		},
+		{
+			Name:        "team",
+			Description: "set your team",
+			Role:        RoleRegistered,
+			Steps: []*Step{
+				{
+					Name:         "team",
+					Prompt:       "Please choose your team",
+					PromptMarkup: teamKeyboard,
+					Validate:     validateTeam,
+				},
+			},
+			Handler:  registerTeam,
+			Callback: chooseTeam,
+		},
+		{
+			Name:        "code",
+			Description: "send the code",
+			Role:        RoleRegistered,
+			DuringGame:  true,
+			Prompt:      "Please provide the code",
+			Handler:     sendCode,
+		},
+		{
+			Name:        "codes",
+			Description: "get the codes",
+			Role:        RoleRegistered,
+			Handler:     listCodes,
+			Callback:    showCodesPage,
+		},
+		{
+			Name:        "top",
+			Description: "get the top",
+			Role:        RoleRegistered,
+			Handler:     listTop,
+		},
+		{
+			Name:        "teamtop",
+			Description: "get the team standings",
+			Role:        RoleRegistered,
+			Handler:     listTeamTop,
+		},
+		{
+			Name:        "history",
+			Description: "get your finds in order",
+			Role:        RoleRegistered,
+			Steps: []*Step{
+				{
+					Name:     "player",
+					Prompt:   "Please provide the player",
+					Optional: true,
+				},
+			},
+			Handler: showHistory,
+		},
+		{
+			Name:        "whoami",
+			Description: "get your username and team",
+			Role:        RoleAnyone,
+			Handler:     whoami,
+		},
+		{
+			Name:        "answer",
+			Description: "send the answer for a puzzle",
+			Role:        RoleRegistered,
+			DuringGame:  true,
+			Steps: []*Step{
+				{
+					Name:     "set",
+					Prompt:   "Please provide the puzzle",
+					Validate: validatePuzzleSet,
+				},
+				{
+					Name:   "answer",
+					Prompt: "Please provide the answer",
+				},
+			},
+			Handler: answerPair,
+		},
+		{
+			Name:        "puzzles",
+			Description: "get the list of puzzles",
+			Role:        RoleRegistered,
+			Handler:     listPuzzleSets,
+		},
+		{
+			Name:        "teams",
+			Description: "get the list of teams",
+			Role:        RoleAnyone,
+			Handler:     listTeams,
+		},
+		{
+			Name:        "what",
+			Aliases:     []string{"start"},
+			Description: "get the list of commands",
+			Role:        RoleAnyone,
+			Handler:     sendHelp,
+		},
+		{
+			Name:        "cancel",
+			Description: "cancel the current command",
+			Role:        RoleAnyone,
+			Handler:     cancelConversation,
+		},
+		{
+			Name:        "admin",
+			Description: "become an admin",
+			Role:        RoleRegistered,
+			ChatTypes:   []string{"private"},
+			Hidden:      true,
+			Prompt:      "Please provide the secret",
+			Handler: func(a *App, req *Request) error {
+				return updateAdmin(a, req, true)
+			},
+		},
+		{
+			Name:        "stopadmin",
+			Description: "stop being an admin",
+			Role:        RoleRegistered,
+			ChatTypes:   []string{"private"},
+			Hidden:      true,
+			Prompt:      "Please provide the secret",
+			Handler: func(a *App, req *Request) error {
+				return updateAdmin(a, req, false)
+			},
+		},
+		{
+			Name:        "addcode",
+			Description: "add a code",
+			Role:        RoleAdmin,
+			ChatTypes:   []string{"private"},
+			Steps: []*Step{
+				{
+					Name:     "code",
+					Prompt:   "Please provide the code",
+					Validate: validateNewCode,
+				},
+				{
+					Name:   "room",
+					Prompt: "Please provide the room",
+				},
+				{
+					Name:     "points",
+					Prompt:   "Please provide the points, " + strconv.Itoa(defaultCodePoints) + " by default, negative for a penalty code",
+					Optional: true,
+					Validate: validatePoints,
+				},
+				{
+					Name:     "note",
+					Prompt:   "Please provide the note",
+					Optional: true,
+				},
+			},
+			Handler: addCode,
+		},
+		{
+			Name:        "removecode",
+			Description: "remove a code",
+			Role:        RoleAdmin,
+			ChatTypes:   []string{"private"},
+			Prompt:      "Please provide the code",
+			Handler:     removeCode,
+			Callback:    confirmRemoveCode,
+		},
+		{
+			Name:        "addteam",
+			Description: "add a team",
+			Role:        RoleAdmin,
+			ChatTypes:   []string{"private"},
+			Steps: []*Step{
+				{
+					Name:     "name",
+					Prompt:   "Please provide the team name",
+					Validate: validateNewTeam,
+				},
+				{
+					Name:     "emoji",
+					Prompt:   "Please provide the team emoji",
+					Optional: true,
+					Validate: validateEmoji,
+				},
+				{
+					Name:     "display_name",
+					Prompt:   "Please provide the display name",
+					Optional: true,
+				},
+			},
+			Handler: addTeam,
+		},
+		{
+			Name:        "renameteam",
+			Description: "rename a team",
+			Role:        RoleAdmin,
+			ChatTypes:   []string{"private"},
+			Steps: []*Step{
+				{
+					Name:     "team",
+					Prompt:   "Please provide the team",
+					Validate: validateTeam,
+				},
+				{
+					Name:     "name",
+					Prompt:   "Please provide the new team name",
+					Validate: validateNewTeam,
+				},
+			},
+			Handler: renameTeam,
+		},
+		{
+			Name:        "teamname",
+			Description: "set the display name of a team",
+			Role:        RoleAdmin,
+			ChatTypes:   []string{"private"},
+			Steps: []*Step{
+				{
+					Name:     "team",
+					Prompt:   "Please provide the team",
+					Validate: validateTeam,
+				},
+				{
+					Name:     "display_name",
+					Prompt:   "Please provide the display name",
+					Optional: true,
+				},
+			},
+			Handler: setTeamDisplayName,
+		},
+		{
+			Name:        "teamemoji",
+			Description: "set the emoji of a team",
+			Role:        RoleAdmin,
+			ChatTypes:   []string{"private"},
+			Steps: []*Step{
+				{
+					Name:     "team",
+					Prompt:   "Please provide the team",
+					Validate: validateTeam,
+				},
+				{
+					Name:     "emoji",
+					Prompt:   "Please provide the emoji",
+					Optional: true,
+					Validate: validateEmoji,
+				},
+			},
+			Handler: setTeamEmoji,
+		},
+		{
+			Name:        "removeteam",
+			Description: "remove a team without players",
+			Role:        RoleAdmin,
+			ChatTypes:   []string{"private"},
+			Steps: []*Step{
+				{
+					Name:     "team",
+					Prompt:   "Please provide the team",
+					Validate: validateTeam,
+				},
+			},
+			Handler: removeTeam,
+		},
+		{
+			Name:        "addpuzzle",
+			Description: "add a puzzle",
+			Role:        RoleAdmin,
+			ChatTypes:   []string{"private"},
+			Steps: []*Step{
+				{
+					Name:     "name",
+					Prompt:   "Please provide the name of the puzzle",
+					Validate: validateNewPuzzleSet,
+				},
+			},
+			Handler: addPuzzleSet,
+		},
+		{
+			Name:        "addanswer",
+			Description: "add an answer to a puzzle",
+			Role:        RoleAdmin,
+			ChatTypes:   []string{"private"},
+			Steps: []*Step{
+				{
+					Name:     "set",
+					Prompt:   "Please provide the puzzle",
+					Validate: validatePuzzleSet,
+				},
+				{
+					Name:   "answer",
+					Prompt: "Please provide the answer",
+				},
+			},
+			Handler: addPair,
+		},
+		{
+			Name:        "listanswers",
+			Description: "list the answers of a puzzle",
+			Role:        RoleAdmin,
+			ChatTypes:   []string{"private"},
+			Steps: []*Step{
+				{
+					Name:     "set",
+					Prompt:   "Please provide the puzzle",
+					Validate: validatePuzzleSet,
+				},
+			},
+			Handler: listPair,
+		},
+		{
+			Name:        "addalias",
+			Description: "accept another spelling of an answer",
+			Role:        RoleAdmin,
+			ChatTypes:   []string{"private"},
+			Steps: []*Step{
+				{
+					Name:     "set",
+					Prompt:   "Please provide the puzzle",
+					Validate: validatePuzzleSet,
+				},
+				{
+					Name:   "answer",
+					Prompt: "Please provide the answer",
+				},
+				{
+					Name:   "alias",
+					Prompt: "Please provide the other spelling",
+				},
+			},
+			Handler: addAlias,
+		},
+		{
+			Name:        "puzzlematch",
+			Description: "set how strictly answers of a puzzle are matched",
+			Role:        RoleAdmin,
+			ChatTypes:   []string{"private"},
+			Steps: []*Step{
+				{
+					Name:     "set",
+					Prompt:   "Please provide the puzzle",
+					Validate: validatePuzzleSet,
+				},
+				{
+					Name:     "matching",
+					Prompt:   "Please provide the matching: '" + matchingExact + "', '" + matchingNormal + "' or '" + matchingFuzzy + "'",
+					Validate: validateMatching,
+				},
+				{
+					Name:     "tolerance",
+					Prompt:   "Please provide the number of typos forgiven in fuzzy matching, " + strconv.Itoa(defaultTolerance) + " by default",
+					Optional: true,
+					Validate: validateTolerance,
+				},
+			},
+			Handler: setPuzzleMatching,
+		},
+		{
+			Name:        "startgame",
+			Description: "schedule the game or resume the paused one",
+			Role:        RoleAdmin,
+			ChatTypes:   []string{"private"},
+			Steps: []*Step{
+				{
+					Name:     "start",
+					Prompt:   "Please provide the start in UTC like 18:00 or 2006-01-02T18:00, or a delay like 30m, now by default",
+					Optional: true,
+					Validate: validateGameTime,
+				},
+				{
+					Name:     "end",
+					Prompt:   "Please provide the end in UTC or the duration of the game like 3h, /endgame by default",
+					Optional: true,
+					Validate: validateGameTime,
+				},
+			},
+			Handler: startGame,
+		},
+		{
+			Name:        "pausegame",
+			Description: "pause the game",
+			Role:        RoleAdmin,
+			ChatTypes:   []string{"private"},
+			Handler:     pauseGame,
+		},
+		{
+			Name:        "endgame",
+			Description: "end the game and send the final standings",
+			Role:        RoleAdmin,
+			ChatTypes:   []string{"private"},
+			Handler:     endGame,
+		},
+	}
+}
+
+func findCommand(commands []*Command, name string) *Command {
+	for _, command := range commands {
+		if command.Name == name {
+			return command
+		}
+		for _, alias := range command.Aliases {
+			if alias == name {
+				return command
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Command) allowsChatType(chatType string) bool {
+	if len(c.ChatTypes) == 0 {
+		return true
+	}
+	for _, allowed := range c.ChatTypes {
+		if allowed == chatType {
+			return true
+		}
+	}
+	return false
+}
+
+// isPublic reports whether the command is offered to every user
+func (c *Command) isPublic() bool {
+	return c.Role != RoleAdmin && !c.Hidden
+}
+
+func setCommandsMenu(bot Sender, commands []*Command) error {
+	menu := []tgbotapi.BotCommand{}
+	for _, command := range commands {
+		if !command.isPublic() {
+			continue
+		}
+		menu = append(menu, tgbotapi.BotCommand{
+			Command:     command.Name,
+			Description: command.Description,
+		})
+	}
+	if _, err := bot.Request(tgbotapi.NewSetMyCommands(menu...)); err != nil {
+		log.Printf("failed to set commands: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+func sendHelp(a *App, req *Request) error {
+	messageString := "I can help you with the following commands:\n"
+	for _, command := range a.commands {
+		if command.isPublic() {
+			messageString += "/" + command.Name + " - " + command.Description + "\n"
+		}
+	}
+	if req.User != nil && req.User.Admin {
+		for _, command := range a.commands {
+			if !command.isPublic() {
+				messageString += "/" + command.Name + " - " + command.Description + "\n"
+			}
+		}
+	}
+	msg := tgbotapi.NewMessage(req.ChatID, messageString)
+	a.bot.Send(msg)
+	return nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type TableConfig struct {
+	UserProfile     string `json:"user_profile"`
+	Username        string `json:"username"` // reserved usernames
+	Team            string `json:"team"`
+	DozorCode       string `json:"dozor_code"`
+	Room            string `json:"room"`            // first found code of every room
+	WaitingCommand  string `json:"waiting_command"` // conversations in progress
+	PuzzleSet       string `json:"puzzle_set"`
+	PuzzleAnswer    string `json:"puzzle_answer"`
+	PairA           string `json:"pair_a"` // legacy answers of puzzle a3
+	PairB           string `json:"pair_b"` // legacy answers of puzzle b1
+	Game            string `json:"game"`
+	ProcessedUpdate string `json:"processed_update"`
+}
+
+// Config holds the settings that differ between games and environments.
+// It is read from the JSON file in BOT_CONFIG_FILE, if any, and then from
+// BOT_* environment variables, which take precedence.
+type Config struct {
+	Region string      `json:"region"`
+	Tables TableConfig `json:"tables"`
+	// Teams were built into the bot before teams were stored in the
+	// database. They are only added to an empty Team table.
+	Teams []string `json:"teams"`
+	// AdminSecretHash is the hex encoded SHA-256 of the /admin secret
+	AdminSecretHash string `json:"admin_secret_hash"`
+	// FirstFindBonus is added to the points of the first code found in
+	// each room
+	FirstFindBonus int `json:"first_find_bonus"`
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		Region: "eu-central-1",
+		Tables: TableConfig{
+			UserProfile:     "UserProfile",
+			Username:        "Username",
+			Team:            "Team",
+			DozorCode:       "DozorCode",
+			Room:            "Room",
+			WaitingCommand:  "WaitingCommand",
+			PuzzleSet:       "PuzzleSet",
+			PuzzleAnswer:    "PuzzleAnswer",
+			PairA:           "PairA",
+			PairB:           "PairB",
+			Game:            "Game",
+			ProcessedUpdate: "ProcessedUpdate",
+		},
+		Teams:           []string{"A", "B", "C", "D"},
+		AdminSecretHash: "9cfc73c0ff8498aa083c2be9c7449f7894e9c0a9621422fec74c3361ab8633dc",
+	}
+}
+
+func loadConfig() (*Config, error) {
+	config := defaultConfig()
+
+	if path := os.Getenv("BOT_CONFIG_FILE"); path != "" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		decoder := json.NewDecoder(file)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(config); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	}
+
+	overrides := map[string]*string{
+		"BOT_REGION":                 &config.Region,
+		"BOT_TABLE_USER_PROFILE":     &config.Tables.UserProfile,
+		"BOT_TABLE_USERNAME":         &config.Tables.Username,
+		"BOT_TABLE_TEAM":             &config.Tables.Team,
+		"BOT_TABLE_DOZOR_CODE":       &config.Tables.DozorCode,
+		"BOT_TABLE_ROOM":             &config.Tables.Room,
+		"BOT_TABLE_WAITING_COMMAND":  &config.Tables.WaitingCommand,
+		"BOT_TABLE_PUZZLE_SET":       &config.Tables.PuzzleSet,
+		"BOT_TABLE_PUZZLE_ANSWER":    &config.Tables.PuzzleAnswer,
+		"BOT_TABLE_PAIR_A":           &config.Tables.PairA,
+		"BOT_TABLE_PAIR_B":           &config.Tables.PairB,
+		"BOT_TABLE_GAME":             &config.Tables.Game,
+		"BOT_TABLE_PROCESSED_UPDATE": &config.Tables.ProcessedUpdate,
+		"BOT_ADMIN_SECRET_HASH":      &config.AdminSecretHash,
+	}
+	for name, value := range overrides {
+		if env, ok := os.LookupEnv(name); ok {
+			*value = strings.TrimSpace(env)
+		}
+	}
+	if env, ok := os.LookupEnv("BOT_TEAMS"); ok {
+		config.Teams = nil
+		for _, team := range strings.Split(env, ",") {
+			if team = strings.TrimSpace(team); team != "" {
+				config.Teams = append(config.Teams, team)
+			}
+		}
+	}
+	if env, ok := os.LookupEnv("BOT_FIRST_FIND_BONUS"); ok {
+		bonus, err := strconv.Atoi(strings.TrimSpace(env))
+		if err != nil {
+			return nil, fmt.Errorf("invalid BOT_FIRST_FIND_BONUS: %w", err)
+		}
+		config.FirstFindBonus = bonus
+	}
+
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	return config, nil
+}
+
+func (c *Config) validate() error {
+	if c.Region == "" {
+		return errors.New("region is empty")
+	}
+
+	tables := map[string]string{
+		"user_profile":     c.Tables.UserProfile,
+		"username":         c.Tables.Username,
+		"team":             c.Tables.Team,
+		"dozor_code":       c.Tables.DozorCode,
+		"room":             c.Tables.Room,
+		"waiting_command":  c.Tables.WaitingCommand,
+		"puzzle_set":       c.Tables.PuzzleSet,
+		"puzzle_answer":    c.Tables.PuzzleAnswer,
+		"pair_a":           c.Tables.PairA,
+		"pair_b":           c.Tables.PairB,
+		"game":             c.Tables.Game,
+		"processed_update": c.Tables.ProcessedUpdate,
+	}
+	usedBy := make(map[string]string)
+	for name, table := range tables {
+		if table == "" {
+			return fmt.Errorf("table %s is empty", name)
+		}
+		if other, ok := usedBy[table]; ok {
+			return fmt.Errorf("tables %s and %s both use %s", name, other, table)
+		}
+		usedBy[table] = name
+	}
+
+	for _, team := range c.Teams {
+		if team == "" || len(team) > maxTeamNameLength || strings.ContainsAny(team, " \t\n") {
+			return fmt.Errorf("team %q is not a valid team name", team)
+		}
+	}
+
+	if hash, err := hex.DecodeString(c.AdminSecretHash); err != nil || len(hash) != 32 {
+		return errors.New("admin_secret_hash is not a hex encoded SHA-256 hash")
+	}
+	if c.FirstFindBonus < 0 {
+		return errors.New("first_find_bonus is negative")
+	}
+	return nil
+}
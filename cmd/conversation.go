@@ -0,0 +1,263 @@
+package main
+
+import (
+	"log"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// conversationTimeout is how long, in seconds, a conversation waits for the
+// next message of the user
+const conversationTimeout = 300
+
+// Step is one input of a command. Commands with several steps are filled in
+// as a dialog, one message per step.
+type Step struct {
+	Name         string
+	Prompt       string
+	PromptMarkup func(a *App) interface{}
+	// Optional steps are skipped by sending "-"
+	Optional bool
+	// Validate returns why the value is rejected or an empty string
+	Validate func(a *App, value string) (string, error)
+}
+
+// steps returns the inputs of the command. A single Prompt is a dialog of
+// one step named "arg".
+func (c *Command) steps() []*Step {
+	if len(c.Steps) > 0 {
+		return c.Steps
+	}
+	if c.Prompt != "" {
+		return []*Step{
+			{
+				Name:         "arg",
+				Prompt:       c.Prompt,
+				PromptMarkup: c.PromptMarkup,
+			},
+		}
+	}
+	return nil
+}
+
+func stepIndex(steps []*Step, state string) int {
+	// conversations saved before states existed are at the first step
+	if state == "" && len(steps) > 0 {
+		return 0
+	}
+	for i, step := range steps {
+		if step.Name == state {
+			return i
+		}
+	}
+	return -1
+}
+
+// splitArgs splits inline arguments into at most n values separated by
+// spaces; the last value takes the rest of the line.
+func splitArgs(args string, n int) []string {
+	values := []string{}
+	for len(values) < n-1 {
+		args = strings.TrimSpace(args)
+		i := strings.IndexAny(args, " \t\n")
+		if i < 0 {
+			break
+		}
+		values = append(values, args[:i])
+		args = args[i+1:]
+	}
+	if args = strings.TrimSpace(args); args != "" && n > 0 {
+		values = append(values, args)
+	}
+	return values
+}
+
+func allOptional(steps []*Step) bool {
+	for _, step := range steps {
+		if !step.Optional {
+			return false
+		}
+	}
+	return true
+}
+
+// checkStep returns the cleaned up value of the step and, when the value is
+// rejected, the message explaining why.
+func (a *App) checkStep(step *Step, value string) (string, string, error) {
+	value = strings.TrimSpace(value)
+	if step.Optional && value == "-" {
+		return "", "", nil
+	}
+	if value == "" {
+		return "", step.Prompt, nil
+	}
+	if step.Validate != nil {
+		problem, err := step.Validate(a, value)
+		if err != nil || problem != "" {
+			return "", problem, err
+		}
+	}
+	return value, "", nil
+}
+
+func (a *App) sendStepMessage(chatID int64, step *Step, text string) {
+	msg := tgbotapi.NewMessage(chatID, text)
+	if step.PromptMarkup != nil {
+		msg.ReplyMarkup = step.PromptMarkup(a)
+	}
+	a.bot.Send(msg)
+}
+
+// askStep saves the conversation at the given step and prompts for it
+func (a *App) askStep(conversation *Conversation, chatID int64, step *Step) error {
+	conversation.State = step.Name
+	if err := a.store.PutConversation(conversation); err != nil {
+		return err
+	}
+
+	prompt := step.Prompt
+	if step.Optional {
+		prompt += " (or - to skip)"
+	}
+	a.sendStepMessage(chatID, step, prompt)
+	return nil
+}
+
+func (a *App) runCommand(req *Request, fields map[string]string) error {
+	req.Arg = fields["arg"]
+	req.Fields = fields
+	return req.Command.Handler(a, req)
+}
+
+// startCommand runs the command when its inline arguments fill every step,
+// e.g. /code ABC123, and starts a conversation for the missing ones otherwise.
+func (a *App) startCommand(req *Request, message *tgbotapi.Message) error {
+	steps := req.Command.steps()
+	args := splitArgs(message.CommandArguments(), len(steps))
+
+	conversation := &Conversation{
+		FromID:    req.FromID,
+		Command:   req.Command.Name,
+		Fields:    make(map[string]string),
+		Timestamp: int64(message.Date),
+	}
+	for i, step := range steps {
+		if i >= len(args) {
+			if (len(args) > 0 || allOptional(steps)) && allOptional(steps[i:]) {
+				// inline arguments leave out the optional steps at the end,
+				// commands with only optional steps run without asking
+				break
+			}
+			return a.askStep(conversation, req.ChatID, step)
+		}
+		value, problem, err := a.checkStep(step, args[i])
+		if err != nil {
+			return err
+		}
+		if problem != "" {
+			// wait at this step, the problem asks for the value again
+			conversation.State = step.Name
+			if err := a.store.PutConversation(conversation); err != nil {
+				return err
+			}
+			a.sendStepMessage(req.ChatID, step, problem)
+			return nil
+		}
+		conversation.Fields[step.Name] = value
+	}
+
+	return a.runCommand(req, conversation.Fields)
+}
+
+// continueConversation takes a plain text message as the input of the step
+// the user's conversation is waiting for.
+func (a *App) continueConversation(message *tgbotapi.Message) error {
+	fromID, chatID := message.From.ID, message.Chat.ID
+
+	conversation, err := a.store.GetConversation(fromID)
+	if err != nil {
+		return err
+	}
+	if conversation != nil && conversation.Timestamp+conversationTimeout < int64(message.Date) {
+		// the conversation has expired
+		if err := a.store.DeleteConversation(fromID); err != nil {
+			return err
+		}
+		conversation = nil
+	}
+	if conversation == nil {
+		msg := tgbotapi.NewMessage(chatID, "I don't understand you")
+		msg.ReplyMarkup = tgbotapi.NewRemoveKeyboard(true)
+		a.bot.Send(msg)
+		return nil
+	}
+
+	command := findCommand(a.commands, conversation.Command)
+	steps := []*Step{}
+	if command != nil {
+		steps = command.steps()
+	}
+	i := stepIndex(steps, conversation.State)
+	if i < 0 {
+		if err := a.store.DeleteConversation(fromID); err != nil {
+			return err
+		}
+		msg := tgbotapi.NewMessage(chatID, "Wrong behavior. Cannot handle command "+conversation.Command+". Please contact the admin")
+		a.bot.Send(msg)
+		return nil
+	}
+
+	step := steps[i]
+	conversation.Timestamp = int64(message.Date)
+	value, problem, err := a.checkStep(step, message.Text)
+	if err != nil {
+		return err
+	}
+	if problem != "" {
+		// stay at the same step
+		if err := a.store.PutConversation(conversation); err != nil {
+			return err
+		}
+		a.sendStepMessage(chatID, step, problem)
+		return nil
+	}
+	conversation.Fields[step.Name] = value
+
+	if i+1 < len(steps) {
+		return a.askStep(conversation, chatID, steps[i+1])
+	}
+
+	// the requirements are checked again in case they changed during the dialog
+	err = a.handle(func(a *App, req *Request) error {
+		return a.runCommand(req, conversation.Fields)
+	})(a, newRequest(command, message))
+	if err != nil && isRetriable(err) {
+		// the retry of the update finds the dialog at its last step
+		return err
+	}
+	if err := a.store.DeleteConversation(fromID); err != nil {
+		log.Printf("failed to delete conversation: %v\n", err)
+	}
+	return err
+}
+
+func cancelConversation(a *App, req *Request) error {
+	conversation, err := a.store.GetConversation(req.FromID)
+	if err != nil {
+		return err
+	}
+	if conversation == nil {
+		msg := tgbotapi.NewMessage(req.ChatID, "There is nothing to cancel")
+		a.bot.Send(msg)
+		return nil
+	}
+
+	if err := a.store.DeleteConversation(req.FromID); err != nil {
+		return err
+	}
+	msg := tgbotapi.NewMessage(req.ChatID, "Command /"+conversation.Command+" was cancelled")
+	msg.ReplyMarkup = tgbotapi.NewRemoveKeyboard(true)
+	a.bot.Send(msg)
+	return nil
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// over reports whether the game reached its end at now
+func (g *Game) over(now int64) bool {
+	return g.EndsAt != 0 && now >= g.EndsAt
+}
+
+// gameClosed returns why codes and answers are refused at now, or an empty
+// string while the game is running
+func gameClosed(game *Game, now int64) string {
+	switch {
+	case game == nil:
+		return "The game has not started yet"
+	case game.over(now):
+		return "The game is over"
+	case now < game.StartsAt:
+		return "The game starts at " + formatFoundAt(game.StartsAt)
+	case game.Paused:
+		return "The game is paused"
+	}
+	return ""
+}
+
+// describeGame tells admins when the game runs
+func describeGame(game *Game, now int64) string {
+	description := "The game starts now"
+	if game.StartsAt > now {
+		description = "The game starts at " + formatFoundAt(game.StartsAt)
+	}
+	if game.EndsAt != 0 {
+		return description + " and ends at " + formatFoundAt(game.EndsAt)
+	}
+	return description + " and runs until /endgame"
+}
+
+// gameTimeLayouts are the accepted forms of start and end times, in UTC
+var gameTimeLayouts = []string{"2006-01-02T15:04", "15:04"}
+
+// parseGameTime reads a time of day, a date and time or a duration after
+// base. A time of day that has passed already means the next day.
+func parseGameTime(value string, base int64) (int64, error) {
+	if duration, err := time.ParseDuration(value); err == nil {
+		return base + int64(duration.Seconds()), nil
+	}
+
+	for _, layout := range gameTimeLayouts {
+		parsed, err := time.ParseInLocation(layout, value, time.UTC)
+		if err != nil {
+			continue
+		}
+		if layout == "15:04" {
+			year, month, day := time.Unix(base, 0).UTC().Date()
+			parsed = time.Date(year, month, day, parsed.Hour(), parsed.Minute(), 0, 0, time.UTC)
+			if parsed.Unix() < base {
+				parsed = parsed.AddDate(0, 0, 1)
+			}
+		}
+		return parsed.Unix(), nil
+	}
+	return 0, errors.New("unknown time format")
+}
+
+func validateGameTime(a *App, value string) (string, error) {
+	if _, err := parseGameTime(value, 0); err != nil {
+		return "Please provide a time in UTC like 18:00 or 2006-01-02T18:00, or a duration like 30m", nil
+	}
+	return "", nil
+}
+
+// startGame schedules a new game, or resumes the paused one when no times
+// are given
+func startGame(a *App, req *Request) error {
+	game, err := a.store.GetGame()
+	if err != nil {
+		log.Printf("failed to get game: %v\n", err)
+		return err
+	}
+
+	if game != nil && game.over(req.Date) && game.AnnouncedAt == 0 {
+		// nobody sent a command since the end, the new game must not
+		// replace the standings of the old one
+		if _, err := announceGame(a, game, req.Date); err != nil {
+			return err
+		}
+	}
+
+	start, end := req.Fields["start"], req.Fields["end"]
+	running := game != nil && !game.over(req.Date) && game.AnnouncedAt == 0
+	if running && start == "" && end == "" {
+		if !game.Paused {
+			msg := tgbotapi.NewMessage(req.ChatID, "The game is already scheduled. "+describeGame(game, req.Date))
+			a.bot.Send(msg)
+			return nil
+		}
+
+		game.Paused = false
+		if err := a.store.PutGame(game); err != nil {
+			log.Printf("failed to put game: %v\n", err)
+			return err
+		}
+		msg := tgbotapi.NewMessage(req.ChatID, "The game is resumed")
+		a.bot.Send(msg)
+		return nil
+	}
+
+	game = &Game{StartsAt: req.Date}
+	if start != "" {
+		game.StartsAt, _ = parseGameTime(start, req.Date)
+	}
+	if end != "" {
+		game.EndsAt, _ = parseGameTime(end, game.StartsAt)
+		if game.EndsAt <= game.StartsAt {
+			msg := tgbotapi.NewMessage(req.ChatID, "The game must end after it starts")
+			a.bot.Send(msg)
+			return nil
+		}
+	}
+	if err := a.store.PutGame(game); err != nil {
+		log.Printf("failed to put game: %v\n", err)
+		return err
+	}
+
+	msg := tgbotapi.NewMessage(req.ChatID, describeGame(game, req.Date))
+	a.bot.Send(msg)
+	return nil
+}
+
+func pauseGame(a *App, req *Request) error {
+	game, err := a.store.GetGame()
+	if err != nil {
+		log.Printf("failed to get game: %v\n", err)
+		return err
+	}
+	if game == nil || game.over(req.Date) || game.AnnouncedAt != 0 {
+		msg := tgbotapi.NewMessage(req.ChatID, "The game is not running")
+		a.bot.Send(msg)
+		return nil
+	}
+	if game.Paused {
+		msg := tgbotapi.NewMessage(req.ChatID, "The game is already paused")
+		a.bot.Send(msg)
+		return nil
+	}
+
+	game.Paused = true
+	if err := a.store.PutGame(game); err != nil {
+		log.Printf("failed to put game: %v\n", err)
+		return err
+	}
+
+	msg := tgbotapi.NewMessage(req.ChatID, "The game is paused, resume it with /startgame")
+	a.bot.Send(msg)
+	return nil
+}
+
+func endGame(a *App, req *Request) error {
+	game, err := a.store.GetGame()
+	if err != nil {
+		log.Printf("failed to get game: %v\n", err)
+		return err
+	}
+	if game == nil {
+		msg := tgbotapi.NewMessage(req.ChatID, "The game has not started yet")
+		a.bot.Send(msg)
+		return nil
+	}
+	if game.AnnouncedAt != 0 {
+		msg := tgbotapi.NewMessage(req.ChatID, "The game is already over")
+		a.bot.Send(msg)
+		return nil
+	}
+
+	if !game.over(req.Date) {
+		game.EndsAt = req.Date
+		game.Paused = false
+		if err := a.store.PutGame(game); err != nil {
+			log.Printf("failed to put game: %v\n", err)
+			return err
+		}
+	}
+
+	sent, err := announceGame(a, game, req.Date)
+	if err != nil {
+		return err
+	}
+
+	msg := tgbotapi.NewMessage(req.ChatID, "The game is over, the final standings were sent to "+strconv.Itoa(sent)+" players")
+	a.bot.Send(msg)
+	return nil
+}
+
+// announceGame sends the final standings of the game to every registered
+// player once and returns how many players got them. Every player is marked
+// before the send, so an announcement cut short, e.g. by the Lambda
+// timeout, is resumed by the next command without repeating it for anyone.
+func announceGame(a *App, game *Game, now int64) (int, error) {
+	standings, err := finalStandings(a)
+	if err != nil {
+		return 0, err
+	}
+	users, err := a.store.ListUsers()
+	if err != nil {
+		log.Printf("failed to list users: %v\n", err)
+		return 0, err
+	}
+
+	sent := 0
+	for _, user := range users {
+		if user.StandingsSent == game.StartsAt {
+			continue
+		}
+		marked, err := a.store.MarkStandingsSent(user.FromID, game.StartsAt)
+		if err != nil {
+			log.Printf("failed to mark standings sent: %v\n", err)
+			return sent, err
+		}
+		if !marked {
+			continue
+		}
+
+		// the private chat with a user has the id of the user
+		msg := tgbotapi.NewMessage(user.FromID, standings)
+		if _, err := a.bot.Send(msg); err != nil {
+			log.Printf("failed to send standings to %d: %v\n", user.FromID, err)
+			continue
+		}
+		sent++
+	}
+
+	// every player got the standings
+	if _, err := a.store.AnnounceGame(now); err != nil {
+		log.Printf("failed to announce game: %v\n", err)
+		return sent, err
+	}
+	return sent, nil
+}
+
+// finalStandings is the message sent when the game ends
+func finalStandings(a *App) (string, error) {
+	standings := "The game is over! Final standings:\n"
+
+	teams, err := teamStandings(a)
+	if err != nil {
+		return "", err
+	}
+	if len(teams) > 0 {
+		standings += "\nTeams:\n" + formatTeamStandings(teams)
+	}
+
+	top, err := playerTop(a)
+	if err != nil {
+		return "", err
+	}
+	if top == "" {
+		top = "No codes were found\n"
+	}
+	standings += "\nPlayers:\n" + top
+	return standings, nil
+}
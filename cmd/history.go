@@ -0,0 +1,130 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strconv"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Find is a code or a puzzle answer claimed by a player
+type Find struct {
+	FoundAt int64
+	Text    string
+}
+
+// playerFinds returns the codes and answers found by the player in the
+// order they were found. Finds made before found_at was recorded come first.
+func playerFinds(a *App, fromID int64) ([]*Find, int, error) {
+	allCodes, err := a.store.ListCodes()
+	if err != nil {
+		log.Printf("failed to list codes: %v\n", err)
+		return nil, 0, err
+	}
+
+	finds := []*Find{}
+	score := 0
+	for _, dozorCode := range allCodes {
+		if dozorCode.FromID != fromID {
+			continue
+		}
+		points := dozorCode.score()
+		score += points
+		finds = append(finds, &Find{
+			FoundAt: dozorCode.FoundAt,
+			Text:    "code " + dozorCode.Code + " in room " + dozorCode.Room + " " + formatPoints(points),
+		})
+	}
+
+	sets, err := a.store.ListPuzzleSets()
+	if err != nil {
+		log.Printf("failed to list puzzle sets: %v\n", err)
+		return nil, 0, err
+	}
+	for _, set := range sets {
+		answers, err := a.store.ListAnswers(set.Name)
+		if err != nil {
+			log.Printf("failed to list answers: %v\n", err)
+			return nil, 0, err
+		}
+		for _, answer := range answers {
+			if answer.FromID != fromID {
+				continue
+			}
+			finds = append(finds, &Find{
+				FoundAt: answer.FoundAt,
+				Text:    "answer " + answer.Answer + " of " + set.Name,
+			})
+		}
+	}
+
+	sort.SliceStable(finds, func(i, j int) bool {
+		if finds[i].FoundAt != finds[j].FoundAt {
+			return finds[i].FoundAt < finds[j].FoundAt
+		}
+		return finds[i].Text < finds[j].Text
+	})
+	return finds, score, nil
+}
+
+// findUser returns the user with the username, ignoring case
+func findUser(store Store, username string) (*UserProfile, error) {
+	fromID, err := store.FindUsername(username)
+	if err != nil {
+		log.Printf("failed to find username: %v\n", err)
+		return nil, err
+	}
+	if fromID == 0 {
+		return nil, nil
+	}
+	return store.GetUser(fromID)
+}
+
+// showHistory lists the finds of the sender, admins can name another player
+func showHistory(a *App, req *Request) error {
+	user := req.User
+	player := req.Fields["player"]
+	if player != "" && usernameKey(player) != usernameKey(user.Username) {
+		if !user.Admin {
+			msg := tgbotapi.NewMessage(req.ChatID, "Only admins can see the history of other players")
+			a.bot.Send(msg)
+			return nil
+		}
+
+		var err error
+		user, err = findUser(a.store, player)
+		if err != nil {
+			return err
+		}
+		if user == nil {
+			msg := tgbotapi.NewMessage(req.ChatID, "Player "+player+" does not exist")
+			a.bot.Send(msg)
+			return nil
+		}
+	}
+
+	finds, score, err := playerFinds(a, user.FromID)
+	if err != nil {
+		return err
+	}
+	if len(finds) == 0 {
+		msg := tgbotapi.NewMessage(req.ChatID, user.Username+" has not found anything yet")
+		a.bot.Send(msg)
+		return nil
+	}
+
+	history := "Finds of " + user.Username + ":\n"
+	for _, find := range finds {
+		foundAt := "earlier"
+		if find.FoundAt != 0 {
+			foundAt = formatFoundAt(find.FoundAt)
+		}
+		history += foundAt + ": " + find.Text + "\n"
+	}
+	history += "\nCode points: " + strconv.Itoa(score)
+
+	msg := tgbotapi.NewMessage(req.ChatID, history)
+	a.bot.Send(msg)
+	return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// botSender remembers when Telegram rejected the token, which happens after
+// the BotToken secret was rotated.
+type botSender struct {
+	*tgbotapi.BotAPI
+	unauthorized atomic.Bool
+}
+
+func (s *botSender) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	msg, err := s.BotAPI.Send(c)
+	s.check(err)
+	return msg, err
+}
+
+func (s *botSender) Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error) {
+	resp, err := s.BotAPI.Request(c)
+	s.check(err)
+	return resp, err
+}
+
+func (s *botSender) check(err error) {
+	var tgErr *tgbotapi.Error
+	if errors.As(err, &tgErr) && tgErr.Code == http.StatusUnauthorized {
+		s.unauthorized.Store(true)
+	}
+}
+
+// lambdaClients keeps the bot and the store of a warm Lambda container, so
+// the token lookup, getMe, setMyCommands and the DynamoDB session are not
+// repeated for every batch.
+var lambdaClients struct {
+	mu      sync.Mutex
+	secrets SecretProvider
+	config  *Config
+	bot     *botSender
+	store   Store
+}
+
+func getLambdaClients() (*botSender, Store, error) {
+	lambdaClients.mu.Lock()
+	defer lambdaClients.mu.Unlock()
+
+	if lambdaClients.store == nil {
+		store, err := newStore("dynamodb", lambdaClients.config)
+		if err != nil {
+			log.Printf("failed to create store: %v\n", err)
+			return nil, nil, err
+		}
+		lambdaClients.store = store
+	}
+
+	// the token is fetched again once Telegram stops accepting it
+	if lambdaClients.bot == nil || lambdaClients.bot.unauthorized.Load() {
+		bot, err := newBot(lambdaClients.secrets)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		setCommandsMenu(bot, newCommands())
+		lambdaClients.bot = &botSender{BotAPI: bot}
+	}
+
+	return lambdaClients.bot, lambdaClients.store, nil
+}
+
+// handler processes a batch of Kinesis records. When an update fails with a
+// retriable error, the rest of the batch is left unprocessed and reported as
+// failed, so Lambda retries from that record while keeping the order.
+func handler(ctx context.Context, kinesisEvent events.KinesisEvent) (events.KinesisEventResponse, error) {
+	response := events.KinesisEventResponse{
+		BatchItemFailures: []events.KinesisBatchItemFailure{},
+	}
+
+	bot, store, err := getLambdaClients()
+	if err != nil {
+		return response, err
+	}
+
+	app := newApp(bot, store, lambdaClients.config, time.Now)
+
+	for _, record := range kinesisEvent.Records {
+		var update tgbotapi.Update
+		if err := json.Unmarshal([]byte(record.Kinesis.Data), &update); err != nil {
+			// a malformed record will never succeed, so it is not retried
+			log.Println(err)
+			continue
+		}
+
+		err := app.ProcessUpdate(ctx, update)
+		if err != nil {
+			log.Printf("failed to process update %d: %v\n", update.UpdateID, err)
+		}
+
+		retry := err != nil && isRetriable(err)
+		if bot.unauthorized.Load() {
+			// the replies were lost, so the update is processed again with
+			// a fresh token
+			log.Printf("bot token was rejected while processing update %d\n", update.UpdateID)
+			if err := store.UnmarkUpdateProcessed(update.UpdateID); err != nil {
+				log.Printf("failed to unmark update %d: %v\n", update.UpdateID, err)
+			}
+			retry = true
+		}
+		if retry {
+			response.BatchItemFailures = append(response.BatchItemFailures, events.KinesisBatchItemFailure{
+				ItemIdentifier: record.Kinesis.SequenceNumber,
+			})
+			break
+		}
+	}
+
+	return response, nil
+}
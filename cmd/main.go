@@ -4,15 +4,18 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
-	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
-	"net/http"
 	"os"
+	"os/signal"
+	"sort"
 	"strconv"
 	"strings"
+	"syscall"
+	"unicode"
+	"unicode/utf8"
 
-	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -22,277 +25,73 @@ import (
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
-type SecretResponse struct {
-	SecretString string `json:"SecretString"`
-}
-
-type DozorCode struct {
-	Code     string
-	Room     string
-	Username string
-	Note     string
-}
-
-func getBotToken() (string, error) {
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", "http://localhost:2773/secretsmanager/get?secretId=BotToken", nil)
+func newBot(secrets SecretProvider) (*tgbotapi.BotAPI, error) {
+	token, err := secrets.GetSecret()
 	if err != nil {
-		return "", err
+		log.Printf("failed to get bot token: %v\n", err)
+		return nil, err
 	}
 
-	req.Header.Add("X-Aws-Parameters-Secrets-Token", os.Getenv("AWS_SESSION_TOKEN"))
-
-	resp, err := client.Do(req)
+	bot, err := tgbotapi.NewBotAPI(token)
 	if err != nil {
-		return "", err
-	}
-
-	var secret SecretResponse
-	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
-		return "", err
+		log.Printf("failed to create bot: %v\n", err)
+		return nil, err
 	}
-
-	return secret.SecretString, nil
+	return bot, nil
 }
 
-func setCommandsMenu(bot *tgbotapi.BotAPI) error {
-	commands := []tgbotapi.BotCommand{
-		{
-			Command:     "register",
-			Description: "set your username",
-		},
-		{
-			Command:     "team",
-			Description: "set your team",
-		},
-		{
-			Command:     "code",
-			Description: "send the code",
-		},
-		{
-			Command:     "codes",
-			Description: "get the codes",
-		},
-		{
-			Command:     "top",
-			Description: "get the top",
-		},
-		{
-			Command:     "a3",
-			Description: "send the answer for a3",
-		},
-		{
-			Command:     "b1",
-			Description: "send the answer for b1",
-		},
-		{
-			Command:     "what",
-			Description: "get the list of commands",
-		},
-		{
-			Command:     "whoami",
-			Description: "get your username and team",
-		},
-	}
-	if _, err := bot.Request(tgbotapi.NewSetMyCommands(commands...)); err != nil {
-		log.Printf("failed to set commands: %v\n", err)
-		return err
-	}
-	return nil
-}
+// usernames are 2 to 24 letters, digits, '_', '-' or '.'
+const (
+	minUsernameLength = 2
+	maxUsernameLength = 24
+)
 
-func registerUsername(bot *tgbotapi.BotAPI, svc *dynamodb.DynamoDB, fromID int64, chatID int64, username string) error {
-	if username == "" {
-		msg := tgbotapi.NewMessage(chatID, "Please provide a username")
-		bot.Send(msg)
-		return nil
+func validateUsername(a *App, username string) (string, error) {
+	length := utf8.RuneCountInString(username)
+	if length < minUsernameLength || length > maxUsernameLength {
+		return "Username must be from " + strconv.Itoa(minUsernameLength) + " to " + strconv.Itoa(maxUsernameLength) + " characters long", nil
 	}
-
-	// check if the username with this chatID already exists
-	result, err := svc.GetItem(&dynamodb.GetItemInput{
-		TableName: aws.String("UserProfile"),
-		Key: map[string]*dynamodb.AttributeValue{
-			"from_id": {
-				N: aws.String(fmt.Sprint(fromID)),
-			},
-		},
-	})
-	if err != nil {
-		log.Printf("failed to get item: %v\n", err)
-		return err
-	}
-
-	if result.Item != nil {
-		// update the username
-		_, err := svc.UpdateItem(&dynamodb.UpdateItemInput{
-			TableName: aws.String("UserProfile"),
-			Key: map[string]*dynamodb.AttributeValue{
-				"from_id": {
-					N: aws.String(fmt.Sprint(fromID)),
-				},
-			},
-			UpdateExpression: aws.String("set username = :u"),
-			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
-				":u": {
-					S: aws.String(username),
-				},
-			},
-		})
-		if err != nil {
-			log.Printf("failed to update item: %v\n", err)
-			return err
-		}
-	} else {
-		// create a new item
-		_, err := svc.PutItem(&dynamodb.PutItemInput{
-			TableName: aws.String("UserProfile"),
-			Item: map[string]*dynamodb.AttributeValue{
-				"from_id": {
-					N: aws.String(fmt.Sprint(fromID)),
-				},
-				"username": {
-					S: aws.String(username),
-				},
-			},
-		})
-		if err != nil {
-			log.Printf("failed to put item: %v\n", err)
-			return err
+	for _, r := range username {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("_-.", r) {
+			return "Username can only contain letters, digits, '_', '-' and '.'", nil
 		}
 	}
-
-	msg := tgbotapi.NewMessage(chatID, "Nice to meet you, "+username+"!")
-	bot.Send(msg)
-	return nil
+	return "", nil
 }
 
-// valid teams are 'A', 'B, 'C', 'D'
-var validStrings = []string{"A", "B", "C", "D"}
+func registerUsername(a *App, req *Request) error {
+	username := req.Fields["username"]
 
-func isValidTeam(team string) bool {
-	for _, valid := range validStrings {
-		if valid == team {
-			return true
-		}
-	}
-	return false
-}
-
-func registerTeam(bot *tgbotapi.BotAPI, svc *dynamodb.DynamoDB, fromID int64, chatID int64, team string) error {
-	if !isValidTeam(team) {
-		validTeams := ""
-		for _, valid := range validStrings {
-			validTeams += "'" + valid + "' "
-		}
-		msg := tgbotapi.NewMessage(chatID, "Please provide a valid team. Valid teams are "+validTeams)
-		bot.Send(msg)
-		return nil
-	}
-
-	if ok, err := isRegistered(svc, fromID); !ok || err != nil {
-		msg := tgbotapi.NewMessage(chatID, "Please register first")
-		bot.Send(msg)
-		return nil
-	}
-
-	// update the user item with the team
-	_, err := svc.UpdateItem(&dynamodb.UpdateItemInput{
-		TableName: aws.String("UserProfile"),
-		Key: map[string]*dynamodb.AttributeValue{
-			"from_id": {
-				N: aws.String(fmt.Sprint(fromID)),
-			},
-		},
-		UpdateExpression: aws.String("set team = :t"),
-		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
-			":t": {
-				S: aws.String(team),
-			},
-		},
-	})
+	// create the user or update the username
+	reserved, err := a.store.SetUsername(req.FromID, username)
 	if err != nil {
-		log.Printf("failed to update item: %v\n", err)
+		log.Printf("failed to set username: %v\n", err)
 		return err
 	}
-
-	msg := tgbotapi.NewMessage(chatID, "Welcome to team "+team+"!")
-	msg.ReplyMarkup = tgbotapi.NewRemoveKeyboard(true)
-	bot.Send(msg)
-	return nil
-}
-
-func isRegistered(svc *dynamodb.DynamoDB, fromID int64) (bool, error) {
-	// check if the username with this fromID already exists
-	result, err := svc.GetItem(&dynamodb.GetItemInput{
-		TableName: aws.String("UserProfile"),
-		Key: map[string]*dynamodb.AttributeValue{
-			"from_id": {
-				N: aws.String(fmt.Sprint(fromID)),
-			},
-		},
-	})
-	if err != nil {
-		log.Printf("failed to get item: %v\n", err)
-		return false, err
-	}
-
-	if result.Item != nil {
-		return true, nil
-	}
-
-	return false, nil
-}
-
-func getUsername(svc *dynamodb.DynamoDB, fromID int64) (string, error) {
-	// check if the username with this fromID already exists
-	result, err := svc.GetItem(&dynamodb.GetItemInput{
-		TableName: aws.String("UserProfile"),
-		Key: map[string]*dynamodb.AttributeValue{
-			"from_id": {
-				N: aws.String(fmt.Sprint(fromID)),
-			},
-		},
-	})
-	if err != nil {
-		log.Printf("failed to get item: %v\n", err)
-		return "", err
-	}
-
-	if result.Item != nil {
-		if result.Item["username"] != nil {
-			return *result.Item["username"].S, nil
-		}
+	if !reserved {
+		msg := tgbotapi.NewMessage(req.ChatID, "Username "+username+" is already taken, please choose another one")
+		a.bot.Send(msg)
+		return nil
 	}
 
-	return "", nil
+	msg := tgbotapi.NewMessage(req.ChatID, "Nice to meet you, "+username+"!")
+	a.bot.Send(msg)
+	return nil
 }
 
-func getTeam(svc *dynamodb.DynamoDB, fromID int64) (string, error) {
-	// check if the username with this fromID already exists
-	result, err := svc.GetItem(&dynamodb.GetItemInput{
-		TableName: aws.String("UserProfile"),
-		Key: map[string]*dynamodb.AttributeValue{
-			"from_id": {
-				N: aws.String(fmt.Sprint(fromID)),
-			},
-		},
-	})
+func getUsername(store Store, fromID int64) (string, error) {
+	user, err := store.GetUser(fromID)
 	if err != nil {
-		log.Printf("failed to get item: %v\n", err)
 		return "", err
 	}
 
-	if result.Item != nil {
-		if result.Item["team"] != nil {
-			return *result.Item["team"].S, nil
-		}
+	if user != nil {
+		return user.Username, nil
 	}
 
 	return "", nil
 }
 
-var adminSecret = "9cfc73c0ff8498aa083c2be9c7449f7894e9c0a9621422fec74c3361ab8633dc"
-
 func calculateHash(input string) string {
 	// Create a new hash.Hash object using SHA-256
 	hasher := sha256.New()
@@ -309,36 +108,16 @@ func calculateHash(input string) string {
 	return hashString
 }
 
-func updateAdmin(bot *tgbotapi.BotAPI, svc *dynamodb.DynamoDB, fromID int64, chatID int64, secret string, enabled bool) error {
-	if ok, err := isRegistered(svc, fromID); !ok || err != nil {
-		msg := tgbotapi.NewMessage(chatID, "Please register first")
-		bot.Send(msg)
-		return nil
-	}
-
-	if calculateHash(secret) != adminSecret {
-		msg := tgbotapi.NewMessage(chatID, "You are not an admin")
-		bot.Send(msg)
+func updateAdmin(a *App, req *Request, enabled bool) error {
+	if calculateHash(req.Arg) != a.config.AdminSecretHash {
+		msg := tgbotapi.NewMessage(req.ChatID, "You are not an admin")
+		a.bot.Send(msg)
 		return nil
 	}
 
 	// update the user with a new attribute 'admin'
-	_, err := svc.UpdateItem(&dynamodb.UpdateItemInput{
-		TableName: aws.String("UserProfile"),
-		Key: map[string]*dynamodb.AttributeValue{
-			"from_id": {
-				N: aws.String(fmt.Sprint(fromID)),
-			},
-		},
-		UpdateExpression: aws.String("set admin = :a"),
-		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
-			":a": {
-				BOOL: aws.Bool(enabled),
-			},
-		},
-	})
-	if err != nil {
-		log.Printf("failed to update item: %v\n", err)
+	if err := a.store.SetAdmin(req.FromID, enabled); err != nil {
+		log.Printf("failed to set admin: %v\n", err)
 		return err
 	}
 
@@ -348,218 +127,176 @@ func updateAdmin(bot *tgbotapi.BotAPI, svc *dynamodb.DynamoDB, fromID int64, cha
 	} else {
 		messageString = "You are not an admin anymore"
 	}
-	msg := tgbotapi.NewMessage(chatID, messageString)
-	bot.Send(msg)
+	msg := tgbotapi.NewMessage(req.ChatID, messageString)
+	a.bot.Send(msg)
 	return nil
 }
 
-func isAdmin(svc *dynamodb.DynamoDB, fromID int64) (bool, error) {
-	// check if the username with this fromID already exists
-	result, err := svc.GetItem(&dynamodb.GetItemInput{
-		TableName: aws.String("UserProfile"),
-		Key: map[string]*dynamodb.AttributeValue{
-			"from_id": {
-				N: aws.String(fmt.Sprint(fromID)),
-			},
-		},
-	})
-	if err != nil {
-		log.Printf("failed to get item: %v\n", err)
-		return false, err
-	}
+// maxCodeLength keeps "removecode:yes <code>" within the 64 bytes Telegram
+// allows for the data of an inline button
+const maxCodeLength = 48
 
-	if result.Item != nil {
-		if result.Item["admin"] != nil {
-			return *result.Item["admin"].BOOL, nil
-		}
+func validateNewCode(a *App, code string) (string, error) {
+	if len(code) > maxCodeLength {
+		return "Code is too long, the limit is " + strconv.Itoa(maxCodeLength) + " characters", nil
 	}
-
-	return false, nil
+	return "", nil
 }
 
-func addCode(bot *tgbotapi.BotAPI, svc *dynamodb.DynamoDB, fromID int64, chatID int64, commandArgument string) error {
-	if ok, err := isAdmin(svc, fromID); !ok || err != nil {
-		msg := tgbotapi.NewMessage(chatID, "You are not an admin")
-		bot.Send(msg)
-		return nil
-	}
-
-	codeString, roomString, noteString := "", "", ""
-
-	// parse with delimeter '-'
-	if len(commandArgument) > 0 {
-		arguments := strings.Split(commandArgument, "-")
-		if len(arguments) > 0 {
-			codeString = strings.TrimSpace(arguments[0])
-		}
-		if len(arguments) > 1 {
-			roomString = strings.TrimSpace(arguments[1])
-		}
-		if len(arguments) > 2 {
-			noteString = strings.TrimSpace(arguments[2])
-		}
-	}
-
+func addCode(a *App, req *Request) error {
+	codeString, roomString, noteString := req.Fields["code"], req.Fields["room"], req.Fields["note"]
 	if codeString == "" {
-		msg := tgbotapi.NewMessage(chatID, "Please provide a code")
-		bot.Send(msg)
+		msg := tgbotapi.NewMessage(req.ChatID, "Please provide a code")
+		a.bot.Send(msg)
 		return nil
 	}
 
 	if roomString == "" {
-		msg := tgbotapi.NewMessage(chatID, "Please provide a room")
-		bot.Send(msg)
+		msg := tgbotapi.NewMessage(req.ChatID, "Please provide a room")
+		a.bot.Send(msg)
 		return nil
 	}
 
-	dozorCode, err := getCode(svc, codeString)
+	dozorCode, err := a.store.GetCode(codeString)
 	if err != nil {
 		log.Printf("failed to get code: %v\n", err)
 		return err
 	}
 	if dozorCode != nil {
-		msg := tgbotapi.NewMessage(chatID, "Code "+codeString+" already exists. Please delete it or use another code")
-		bot.Send(msg)
+		msg := tgbotapi.NewMessage(req.ChatID, "Code "+codeString+" already exists. Please delete it or use another code")
+		a.bot.Send(msg)
 		return nil
 	}
 
+	points := defaultCodePoints
+	if req.Fields["points"] != "" {
+		points, _ = strconv.Atoi(req.Fields["points"])
+	}
+
 	// create a new code item
-	_, err = svc.PutItem(&dynamodb.PutItemInput{
-		TableName: aws.String("DozorCode"),
-		Item: map[string]*dynamodb.AttributeValue{
-			"code": {
-				S: aws.String(codeString),
-			},
-			"room": {
-				S: aws.String(roomString),
-			},
-			"note": {
-				S: aws.String(noteString),
-			},
-		},
+	err = a.store.PutCode(&DozorCode{
+		Code:   codeString,
+		Room:   roomString,
+		Note:   noteString,
+		Points: points,
 	})
 	if err != nil {
-		log.Printf("failed to put item: %v\n", err)
+		log.Printf("failed to put code: %v\n", err)
 		return err
 	}
 
-	codeMessage := "Code " + codeString + " was added to room " + roomString
+	codeMessage := "Code " + codeString + " (" + formatPoints(points) + ") was added to room " + roomString
 	if noteString != "" {
 		codeMessage += " with note " + noteString
 	}
-	msg := tgbotapi.NewMessage(chatID, codeMessage)
-	bot.Send(msg)
+	msg := tgbotapi.NewMessage(req.ChatID, codeMessage)
+	a.bot.Send(msg)
 
 	return nil
 }
 
-func sendCode(bot *tgbotapi.BotAPI, svc *dynamodb.DynamoDB, fromID int64, chatID int64, codeString string) error {
-	if ok, err := isRegistered(svc, fromID); !ok || err != nil {
-		msg := tgbotapi.NewMessage(chatID, "Please register first")
-		bot.Send(msg)
-		return nil
-	}
-
+func sendCode(a *App, req *Request) error {
+	codeString := req.Arg
 	if codeString == "" {
-		msg := tgbotapi.NewMessage(chatID, "Please provide a code")
-		bot.Send(msg)
+		msg := tgbotapi.NewMessage(req.ChatID, "Please provide a code")
+		a.bot.Send(msg)
 		return nil
 	}
 
-	dozorCode, err := getCode(svc, codeString)
+	// claim the code, the first finder keeps it
+	dozorCode, claimed, err := a.store.ClaimCode(codeString, req.FromID, req.Date)
 	if err != nil {
-		log.Printf("failed to get code: %v\n", err)
+		log.Printf("failed to claim code: %v\n", err)
 		return err
 	}
 	if dozorCode == nil {
-		msg := tgbotapi.NewMessage(chatID, "Code "+codeString+" does not exist")
-		bot.Send(msg)
+		msg := tgbotapi.NewMessage(req.ChatID, "Code "+codeString+" does not exist")
+		a.bot.Send(msg)
 		return nil
 	}
-	if dozorCode.Username != "" {
-		// already found by someone
-		msg := tgbotapi.NewMessage(chatID, "Code "+codeString+" was already found by "+dozorCode.Username)
-		bot.Send(msg)
-	}
-
-	// update the code with the fromID
-	_, err = svc.UpdateItem(&dynamodb.UpdateItemInput{
-		TableName: aws.String("DozorCode"),
-		Key: map[string]*dynamodb.AttributeValue{
-			"code": {
-				S: aws.String(codeString),
-			},
-		},
-		UpdateExpression: aws.String("set from_id = :f"),
-		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
-			":f": {
-				N: aws.String(fmt.Sprint(fromID)),
-			},
-		},
-	})
-	if err != nil {
-		log.Printf("failed to update item: %v\n", err)
-		return err
+	if !claimed {
+		messageString := ""
+		if dozorCode.FromID == req.FromID {
+			messageString = "You have already found the code " + codeString
+		} else {
+			resolveFinder(a.store, dozorCode)
+			finder := dozorCode.Username
+			if finder == "" {
+				finder = "someone else"
+			}
+			messageString = "Code " + codeString + " was already claimed by " + finder
+		}
+		msg := tgbotapi.NewMessage(req.ChatID, messageString)
+		a.bot.Send(msg)
+		return nil
 	}
 
-	username, err := getUsername(svc, fromID)
+	messageString := "Congratulations, " + req.User.Username + "! You found the code " + codeString + " (" + formatPoints(dozorCode.Points) + ")"
+	if dozorCode.Points < 0 {
+		messageString = "Oops, " + req.User.Username + "! The code " + codeString + " is a penalty code (" + formatPoints(dozorCode.Points) + ")"
+	}
+
+	if awardFirstFind(a, dozorCode) {
+		messageString += "\nYou are the first to find a code in room " + dozorCode.Room + ": " + formatPoints(dozorCode.Bonus) + " bonus"
+	}
+
+	msg := tgbotapi.NewMessage(req.ChatID, messageString)
+	a.bot.Send(msg)
+	return nil
+}
+
+// codesPageSize is the number of rooms on one page of /codes
+const codesPageSize = 5
+
+func listCodes(a *App, req *Request) error {
+	codes, markup, err := codesPage(a, req.User, 0)
 	if err != nil {
-		log.Printf("failed to get username: %v\n", err)
 		return err
 	}
 
-	messageString := "Congratulations, " + username + "! You found the code " + codeString
-	msg := tgbotapi.NewMessage(chatID, messageString)
-	bot.Send(msg)
+	msg := tgbotapi.NewMessage(req.ChatID, codes)
+	if markup != nil {
+		msg.ReplyMarkup = markup
+	}
+	a.bot.Send(msg)
 	return nil
 }
 
-func listCodes(bot *tgbotapi.BotAPI, svc *dynamodb.DynamoDB, fromID int64, chatID int64) error {
-	if ok, err := isRegistered(svc, fromID); !ok || err != nil {
-		msg := tgbotapi.NewMessage(chatID, "Please register first")
-		bot.Send(msg)
+func showCodesPage(a *App, req *Request) error {
+	page, err := strconv.Atoi(req.Arg)
+	if err != nil {
+		a.answerCallback(req, "")
 		return nil
 	}
 
-	isUserAdmin, err := isAdmin(svc, fromID)
+	codes, markup, err := codesPage(a, req.User, page)
 	if err != nil {
-		log.Printf("failed to check if user is admin: %v\n", err)
-		isUserAdmin = false
+		return err
 	}
 
+	a.editMessage(req, codes, markup)
+	a.answerCallback(req, "")
+	return nil
+}
+
+// codesPage renders one page of /codes and the buttons to turn pages, nil
+// when everything fits on one page
+func codesPage(a *App, user *UserProfile, page int) (string, *tgbotapi.InlineKeyboardMarkup, error) {
+	isUserAdmin := user.Admin
+
 	// get all codes
-	result, err := svc.Scan(&dynamodb.ScanInput{
-		TableName: aws.String("DozorCode"),
-	})
+	allCodes, err := a.store.ListCodes()
 	if err != nil {
-		log.Printf("failed to scan table: %v\n", err)
-		return err
+		log.Printf("failed to list codes: %v\n", err)
+		return "", nil, err
+	}
+
+	if err := resolveFinders(a.store, allCodes); err != nil {
+		return "", nil, err
 	}
 
 	dozorCodesByRoom := make(map[string][]*DozorCode)
-	for _, item := range result.Items {
-		dozorCode := &DozorCode{
-			Code: *item["code"].S,
-		}
-		if item["room"] != nil {
-			dozorCode.Room = *item["room"].S
-		}
-		if item["from_id"] != nil {
-			fromIDStr := *item["from_id"].N
-			// convert fromIDStr to int64
-			fromID, err := strconv.ParseInt(fromIDStr, 10, 64)
-			if err != nil {
-				log.Printf("failed to parse from_id: %v\n", err)
-			} else {
-				dozorCode.Username, err = getUsername(svc, fromID)
-				if err != nil {
-					log.Printf("failed to get username: %v\n", err)
-				}
-			}
-		}
-		if item["note"] != nil {
-			dozorCode.Note = *item["note"].S
-		}
+	for _, dozorCode := range allCodes {
 		if dozorCode.Username != "" {
 			// if the code was found by the user, put it first
 			dozorCodesByRoom[dozorCode.Room] = append([]*DozorCode{dozorCode}, dozorCodesByRoom[dozorCode.Room]...)
@@ -569,842 +306,402 @@ func listCodes(bot *tgbotapi.BotAPI, svc *dynamodb.DynamoDB, fromID int64, chatI
 		}
 	}
 
+	// rooms are sorted so that pages stay the same between presses
+	rooms := make([]string, 0, len(dozorCodesByRoom))
+	for room := range dozorCodesByRoom {
+		rooms = append(rooms, room)
+	}
+	sort.Strings(rooms)
+
+	pages := (len(rooms) + codesPageSize - 1) / codesPageSize
+	if page >= pages {
+		page = pages - 1
+	}
+	if page < 0 {
+		page = 0
+	}
+
 	codes := ""
 	foundCount := 0
 	totalCount := 0
-	for room, dozorCodes := range dozorCodesByRoom {
-		if dozorCodes == nil || len(dozorCodes) == 0 {
-			continue
-		}
-		codes += room + ":\n"
+	foundPoints := 0
+	for i, room := range rooms {
+		roomCodes := room + ":\n"
 		notFoundCount := 0
-		for _, dozorCode := range dozorCodes {
+		for _, dozorCode := range dozorCodesByRoom[room] {
 			if !isUserAdmin && dozorCode.Username == "" {
 				notFoundCount++
 				totalCount++
 				continue
 			}
 
-			codes += dozorCode.Code + " "
+			roomCodes += dozorCode.Code + " "
 			if dozorCode.Username != "" {
-				codes += "found by " + dozorCode.Username + " "
+				roomCodes += formatPoints(dozorCode.score()) + " found by " + dozorCode.Username + " "
+				if dozorCode.Bonus != 0 {
+					roomCodes += "first in room "
+				}
+			} else {
+				roomCodes += formatPoints(dozorCode.Points) + " "
 			}
 			if isUserAdmin && dozorCode.Note != "" {
-				codes += "note: " + dozorCode.Note + " "
+				roomCodes += "note: " + dozorCode.Note + " "
 			}
-			codes += "\n"
+			roomCodes += "\n"
 
 			if dozorCode.Username != "" {
 				foundCount++
+				foundPoints += dozorCode.score()
 			}
 			totalCount++
 		}
 		if notFoundCount > 0 {
-			codes += "Not found: " + strconv.Itoa(notFoundCount) + " codes\n"
+			roomCodes += "Not found: " + strconv.Itoa(notFoundCount) + " codes\n"
+		}
+		roomCodes += "\n"
+
+		if i/codesPageSize == page {
+			codes += roomCodes
 		}
-		codes += "\n"
 	}
 
-	codes = "Found: " + strconv.Itoa(foundCount) + " codes\n" +
+	codes = "Found: " + strconv.Itoa(foundCount) + " codes, " + strconv.Itoa(foundPoints) + " points\n" +
 		"Left: " + strconv.Itoa(totalCount-foundCount) + " codes\n" +
 		"Total: " + strconv.Itoa(totalCount) + " codes\n\n" +
 		codes
 
-	msg := tgbotapi.NewMessage(chatID, codes)
-	bot.Send(msg)
-	return nil
+	if pages <= 1 {
+		return codes, nil, nil
+	}
+
+	codes += "Page " + strconv.Itoa(page+1) + " of " + strconv.Itoa(pages)
+	buttons := []tgbotapi.InlineKeyboardButton{}
+	if page > 0 {
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData("« Previous", callbackData("codes", strconv.Itoa(page-1))))
+	}
+	if page < pages-1 {
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData("Next »", callbackData("codes", strconv.Itoa(page+1))))
+	}
+	markup := tgbotapi.NewInlineKeyboardMarkup(buttons)
+	return codes, &markup, nil
 }
 
 type TopEntry struct {
+	FromID   int64
 	Username string
 	Teamname string
 	Count    int
+	Score    int
+	// LastFoundAt is when the player reached the score
+	LastFoundAt int64
 }
 
-func listTop(bot *tgbotapi.BotAPI, svc *dynamodb.DynamoDB, fromID int64, chatID int64) error {
-	if ok, err := isRegistered(svc, fromID); !ok || err != nil {
-		msg := tgbotapi.NewMessage(chatID, "Please register first")
-		bot.Send(msg)
-		return nil
-	}
-
+// playerTop returns the players ranked by points, or an empty string when
+// no codes were found yet
+func playerTop(a *App) (string, error) {
 	// get all codes
-	result, err := svc.Scan(&dynamodb.ScanInput{
-		TableName: aws.String("DozorCode"),
-	})
+	allCodes, err := a.store.ListCodes()
 	if err != nil {
-		log.Printf("failed to scan table: %v\n", err)
-		return err
+		log.Printf("failed to list codes: %v\n", err)
+		return "", err
 	}
 
-	dozorCodesByUser := make(map[string][]*DozorCode)
-	for _, item := range result.Items {
-		dozorCode := &DozorCode{
-			Code: *item["code"].S,
-		}
-		if item["room"] != nil {
-			dozorCode.Room = *item["room"].S
-		}
-		if item["from_id"] != nil {
-			fromIDStr := *item["from_id"].N
-			// convert fromIDStr to int64
-			fromID, err := strconv.ParseInt(fromIDStr, 10, 64)
-			if err != nil {
-				log.Printf("failed to parse from_id: %v\n", err)
-			} else {
-				dozorCode.Username, err = getUsername(svc, fromID)
-				if err != nil {
-					log.Printf("failed to get username: %v\n", err)
-				}
-			}
-		}
-		if item["note"] != nil {
-			dozorCode.Note = *item["note"].S
-		}
-		if dozorCode.Username != "" {
-			dozorCodesByUser[dozorCode.Username] = append(dozorCodesByUser[dozorCode.Username], dozorCode)
+	// users are told apart by id, the username is only shown
+	dozorCodesByUser := make(map[int64][]*DozorCode)
+	for _, dozorCode := range allCodes {
+		if dozorCode.FromID != 0 {
+			dozorCodesByUser[dozorCode.FromID] = append(dozorCodesByUser[dozorCode.FromID], dozorCode)
 		}
 	}
 
 	if len(dozorCodesByUser) == 0 {
-		msg := tgbotapi.NewMessage(chatID, "No codes were found yet")
-		bot.Send(msg)
-		return nil
+		return "", nil
 	}
 
 	topEntries := make([]*TopEntry, 0)
-	for username, dozorCodes := range dozorCodesByUser {
-		topEntries = append(topEntries, &TopEntry{
-			Username: username,
+	for fromID, dozorCodes := range dozorCodesByUser {
+		topEntry := &TopEntry{
+			FromID:   fromID,
+			Username: fmt.Sprint(fromID),
 			Count:    len(dozorCodes),
-		})
-	}
-
-	// sort topEntries by count
-	for i := 0; i < len(topEntries); i++ {
-		for j := i + 1; j < len(topEntries); j++ {
-			if topEntries[i].Count < topEntries[j].Count {
-				topEntries[i], topEntries[j] = topEntries[j], topEntries[i]
+		}
+		for _, dozorCode := range dozorCodes {
+			topEntry.Score += dozorCode.score()
+			if dozorCode.FoundAt > topEntry.LastFoundAt {
+				topEntry.LastFoundAt = dozorCode.FoundAt
 			}
 		}
+		topEntries = append(topEntries, topEntry)
 	}
 
-	// find the team for each user
-	for _, topEntry := range topEntries {
-		result, err := svc.Scan(&dynamodb.ScanInput{
-			TableName:        aws.String("UserProfile"),
-			FilterExpression: aws.String("username = :u"),
-			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
-				":u": {
-					S: aws.String(topEntry.Username),
-				},
-			},
-		})
-		if err != nil {
-			log.Printf("failed to scan table: %v\n", err)
-			continue
+	// sort topEntries by score, ties go to the player who got there first
+	sort.Slice(topEntries, func(i, j int) bool {
+		if topEntries[i].Score != topEntries[j].Score {
+			return topEntries[i].Score > topEntries[j].Score
 		}
-		if len(result.Items) > 0 {
-			if result.Items[0]["team"] != nil {
-				topEntry.Teamname = *result.Items[0]["team"].S
-			}
+		if topEntries[i].LastFoundAt != topEntries[j].LastFoundAt {
+			return foundEarlier(topEntries[i].LastFoundAt, topEntries[j].LastFoundAt)
+		}
+		return topEntries[i].FromID < topEntries[j].FromID
+	})
+
+	// find the username and the team for each user
+	fromIDs := make([]int64, 0, len(topEntries))
+	for _, topEntry := range topEntries {
+		fromIDs = append(fromIDs, topEntry.FromID)
+	}
+	users, err := a.store.GetUsers(fromIDs)
+	if err != nil {
+		log.Printf("failed to get users: %v\n", err)
+		return "", err
+	}
+	for _, topEntry := range topEntries {
+		if user, ok := users[topEntry.FromID]; ok {
+			topEntry.Username = user.Username
+			topEntry.Teamname = user.Team
 		}
 	}
 
+	teams, err := a.store.ListTeams()
+	if err != nil {
+		log.Printf("failed to list teams: %v\n", err)
+		return "", err
+	}
+
 	top := ""
 	for i, topEntry := range topEntries {
-		top += strconv.Itoa(i+1) + ". " + topEntry.Username + " " + strconv.Itoa(topEntry.Count)
+		top += strconv.Itoa(i+1) + ". " + topEntry.Username + " " + strconv.Itoa(topEntry.Score) + " points (" + strconv.Itoa(topEntry.Count) + " codes)"
 		if topEntry.Teamname != "" {
-			top += " (team " + topEntry.Teamname + ")"
+			top += " (team " + teamLabel(teams, topEntry.Teamname) + ")"
+		}
+		if topEntry.LastFoundAt != 0 {
+			top += ", last find " + formatFoundAt(topEntry.LastFoundAt)
 		}
 		top += "\n"
 	}
-
-	msg := tgbotapi.NewMessage(chatID, top)
-	bot.Send(msg)
-
-	return nil
+	return top, nil
 }
 
-func removeCode(bot *tgbotapi.BotAPI, svc *dynamodb.DynamoDB, fromID int64, chatID int64, codeString string) error {
-	if ok, err := isAdmin(svc, fromID); !ok || err != nil {
-		msg := tgbotapi.NewMessage(chatID, "You are not an admin")
-		bot.Send(msg)
-		return nil
-	}
-
-	dozorCode, err := getCode(svc, codeString)
+func listTop(a *App, req *Request) error {
+	top, err := playerTop(a)
 	if err != nil {
-		log.Printf("failed to get code: %v\n", err)
 		return err
 	}
-	if dozorCode == nil {
-		msg := tgbotapi.NewMessage(chatID, "Code "+codeString+" does not exist")
-		bot.Send(msg)
-		return nil
+	if top == "" {
+		top = "No codes were found yet"
 	}
 
-	// remove the code
-	_, err = svc.DeleteItem(&dynamodb.DeleteItemInput{
-		TableName: aws.String("DozorCode"),
-		Key: map[string]*dynamodb.AttributeValue{
-			"code": {
-				S: aws.String(codeString),
-			},
-		},
-	})
-	if err != nil {
-		log.Printf("failed to delete item: %v\n", err)
-		return err
-	}
+	msg := tgbotapi.NewMessage(req.ChatID, top)
+	a.bot.Send(msg)
 
-	msg := tgbotapi.NewMessage(chatID, "Code "+codeString+" was removed")
-	bot.Send(msg)
 	return nil
 }
 
-func getCode(svc *dynamodb.DynamoDB, code string) (*DozorCode, error) {
-	// check if the code exists
-	result, err := svc.GetItem(&dynamodb.GetItemInput{
-		TableName: aws.String("DozorCode"),
-		Key: map[string]*dynamodb.AttributeValue{
-			"code": {
-				S: aws.String(code),
-			},
-		},
-	})
+func removeCode(a *App, req *Request) error {
+	codeString := req.Arg
+	dozorCode, err := a.store.GetCode(codeString)
 	if err != nil {
-		log.Printf("failed to get item: %v\n", err)
-		return nil, err
+		log.Printf("failed to get code: %v\n", err)
+		return err
 	}
-
-	if result.Item != nil {
-		dozorCode := &DozorCode{
-			Code: *result.Item["code"].S,
-		}
-		if result.Item["room"] != nil {
-			dozorCode.Room = *result.Item["room"].S
-		}
-		if result.Item["note"] != nil {
-			dozorCode.Note = *result.Item["note"].S
-		}
-		if result.Item["from_id"] != nil {
-			fromIDStr := *result.Item["from_id"].N
-			// convert fromIDStr to int64
-			fromID, err := strconv.ParseInt(fromIDStr, 10, 64)
-			if err != nil {
-				log.Printf("failed to parse from_id: %v\n", err)
-			} else {
-				dozorCode.Username, err = getUsername(svc, fromID)
-				if err != nil {
-					log.Printf("failed to get username: %v\n", err)
-				}
-			}
-		}
-		return dozorCode, nil
+	if dozorCode == nil {
+		msg := tgbotapi.NewMessage(req.ChatID, "Code "+codeString+" does not exist")
+		a.bot.Send(msg)
+		return nil
 	}
 
-	return nil, nil
+	// ask for confirmation, the code is removed by confirmRemoveCode
+	msg := tgbotapi.NewMessage(req.ChatID, "Remove code "+codeString+" from room "+dozorCode.Room+"?")
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Remove", callbackData("removecode", "yes "+codeString)),
+		tgbotapi.NewInlineKeyboardButtonData("Keep", callbackData("removecode", "no "+codeString)),
+	))
+	a.bot.Send(msg)
+	return nil
 }
 
-func answerPair(bot *tgbotapi.BotAPI, svc *dynamodb.DynamoDB, fromID int64, chatID int64, commandArgument string, tablename string) error {
-	if ok, err := isRegistered(svc, fromID); !ok || err != nil {
-		msg := tgbotapi.NewMessage(chatID, "Please register first")
-		bot.Send(msg)
+func confirmRemoveCode(a *App, req *Request) error {
+	args := splitArgs(req.Arg, 2)
+	if len(args) < 2 {
+		a.answerCallback(req, "")
 		return nil
 	}
+	answer, codeString := args[0], args[1]
 
-	if commandArgument == "" {
-		msg := tgbotapi.NewMessage(chatID, "Please provide a answer")
-		bot.Send(msg)
+	if answer != "yes" {
+		a.editMessage(req, "Code "+codeString+" was kept", nil)
+		a.answerCallback(req, "")
 		return nil
 	}
 
-	// check if all the answers found
-	result, err := svc.Scan(&dynamodb.ScanInput{
-		TableName: aws.String(tablename),
-	})
+	dozorCode, err := a.store.GetCode(codeString)
 	if err != nil {
-		log.Printf("failed to scan table: %v\n", err)
+		log.Printf("failed to get code: %v\n", err)
 		return err
 	}
-	allFound := true
-	if result.Items != nil && len(result.Items) > 0 {
-		for _, item := range result.Items {
-			if item["from_id"] == nil {
-				allFound = false
-				break
-			}
-		}
-	}
-	if allFound {
-		msg := tgbotapi.NewMessage(chatID, "All answers were found")
-		bot.Send(msg)
+	if dozorCode == nil {
+		a.editMessage(req, "Code "+codeString+" does not exist", nil)
+		a.answerCallback(req, "")
 		return nil
 	}
 
-	// to lower
-	commandArgument = strings.ToLower(commandArgument)
-	// trim spaces
-	commandArgument = strings.TrimSpace(commandArgument)
-
-	// check table tablename to find the item with 'answer' equal to commandArgument
-	result, err = svc.Scan(&dynamodb.ScanInput{
-		TableName:        aws.String(tablename),
-		FilterExpression: aws.String("answer = :a"),
-		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
-			":a": {
-				S: aws.String(commandArgument),
-			},
-		},
-	})
-	if err != nil {
-		log.Printf("failed to scan table: %v\n", err)
+	// remove the code
+	if err := a.store.DeleteCode(codeString); err != nil {
+		log.Printf("failed to delete code: %v\n", err)
 		return err
 	}
-	if result.Items == nil || len(result.Items) == 0 {
-		msg := tgbotapi.NewMessage(chatID, "Wrong answer")
-		bot.Send(msg)
-		return nil
-	}
 
-	// mark the answer as found
-	_, err = svc.UpdateItem(&dynamodb.UpdateItemInput{
-		TableName: aws.String(tablename),
-		Key: map[string]*dynamodb.AttributeValue{
-			"answer": {
-				S: aws.String(commandArgument),
-			},
-		},
-		UpdateExpression: aws.String("set from_id = :f"),
-		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
-			":f": {
-				N: aws.String(fmt.Sprint(fromID)),
-			},
-		},
-	})
-	if err != nil {
-		log.Printf("failed to update item: %v\n", err)
-		return err
-	}
+	a.editMessage(req, "Code "+codeString+" was removed", nil)
+	a.answerCallback(req, "")
+	return nil
+}
 
-	// check if all the answers found
-	result, err = svc.Scan(&dynamodb.ScanInput{
-		TableName: aws.String(tablename),
-	})
-	if err != nil {
-		log.Printf("failed to scan table: %v\n", err)
-		return err
+// resolveFinder fills in the username of the user who found the code
+func resolveFinder(store Store, dozorCode *DozorCode) {
+	if dozorCode.FromID == 0 {
+		return
 	}
-	allFound = true
-	if result.Items != nil && len(result.Items) > 0 {
-		for _, item := range result.Items {
-			if item["from_id"] == nil {
-				allFound = false
-				break
-			}
-		}
-	}
-	if allFound {
-		msg := tgbotapi.NewMessage(chatID, "All answers were found")
-		bot.Send(msg)
-		return nil
-	}
-
-	username, err := getUsername(svc, fromID)
+	username, err := getUsername(store, dozorCode.FromID)
 	if err != nil {
 		log.Printf("failed to get username: %v\n", err)
-		return err
+		return
 	}
-
-	messageString := "Congratulations, " + username + "! You found the answer " + commandArgument
-	msg := tgbotapi.NewMessage(chatID, messageString)
-	bot.Send(msg)
-	return nil
+	dozorCode.Username = username
 }
 
-func addPair(bot *tgbotapi.BotAPI, svc *dynamodb.DynamoDB, fromID int64, chatID int64, commandArgument string, tablename string) error {
-	if ok, err := isAdmin(svc, fromID); !ok || err != nil {
-		msg := tgbotapi.NewMessage(chatID, "You are not an admin")
-		bot.Send(msg)
-		return nil
+// resolveFinders fills in the usernames of the users who found the codes,
+// loading all of them at once
+func resolveFinders(store Store, dozorCodes []*DozorCode) error {
+	fromIDs := []int64{}
+	for _, dozorCode := range dozorCodes {
+		if dozorCode.FromID != 0 {
+			fromIDs = append(fromIDs, dozorCode.FromID)
+		}
 	}
-
-	if commandArgument == "" {
-		msg := tgbotapi.NewMessage(chatID, "Please provide a answer")
-		bot.Send(msg)
+	if len(fromIDs) == 0 {
 		return nil
 	}
 
-	// to lower
-	commandArgument = strings.ToLower(commandArgument)
-	// trim spaces
-	commandArgument = strings.TrimSpace(commandArgument)
-
-	// check table tablename to find the item with 'answer' equal to commandArgument
-	result, err := svc.Scan(&dynamodb.ScanInput{
-		TableName:        aws.String(tablename),
-		FilterExpression: aws.String("answer = :a"),
-		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
-			":a": {
-				S: aws.String(commandArgument),
-			},
-		},
-	})
+	users, err := store.GetUsers(fromIDs)
 	if err != nil {
-		log.Printf("failed to scan table: %v\n", err)
+		log.Printf("failed to get users: %v\n", err)
 		return err
 	}
-	if result.Items != nil && len(result.Items) > 0 {
-		msg := tgbotapi.NewMessage(chatID, "Answer "+commandArgument+" already exists")
-		bot.Send(msg)
-		return nil
-	}
-
-	// create a new item
-	_, err = svc.PutItem(&dynamodb.PutItemInput{
-		TableName: aws.String(tablename),
-		Item: map[string]*dynamodb.AttributeValue{
-			"answer": {
-				S: aws.String(commandArgument),
-			},
-		},
-	})
-	if err != nil {
-		log.Printf("failed to put item: %v\n", err)
-		return err
+	for _, dozorCode := range dozorCodes {
+		if user, ok := users[dozorCode.FromID]; ok {
+			dozorCode.Username = user.Username
+		}
 	}
-
-	msg := tgbotapi.NewMessage(chatID, "Answer "+commandArgument+" was added")
-	bot.Send(msg)
 	return nil
 }
 
-func listPair(bot *tgbotapi.BotAPI, svc *dynamodb.DynamoDB, fromID int64, chatID int64, tablename string) error {
-	if ok, err := isAdmin(svc, fromID); !ok || err != nil {
-		msg := tgbotapi.NewMessage(chatID, "You are not an admin")
-		bot.Send(msg)
+func whoami(a *App, req *Request) error {
+	user := req.User
+	if user == nil || user.Username == "" {
+		msg := tgbotapi.NewMessage(req.ChatID, "You are not registered")
+		a.bot.Send(msg)
 		return nil
 	}
 
-	// get all answers
-	result, err := svc.Scan(&dynamodb.ScanInput{
-		TableName: aws.String(tablename),
-	})
-	if err != nil {
-		log.Printf("failed to scan table: %v\n", err)
-		return err
+	messageString := ""
+	if user.Team != "" {
+		team, err := a.store.GetTeam(user.Team)
+		if err != nil {
+			log.Printf("failed to get team: %v\n", err)
+			return err
+		}
+		teamString := user.Team
+		if team != nil {
+			teamString = team.label()
+		}
+		messageString = "You are " + user.Username + " from team " + teamString
+	} else {
+		messageString = "You are " + user.Username
 	}
+	msg := tgbotapi.NewMessage(req.ChatID, messageString)
+	a.bot.Send(msg)
+	return nil
+}
 
-	answers := ""
-	foundCount := 0
-	for _, item := range result.Items {
-		answerString := ""
-		if item["answer"] != nil {
-			answerString += *item["answer"].S
+func newStore(kind string, config *Config) (Store, error) {
+	var store Store
+	switch kind {
+	case "dynamodb":
+		sess, err := session.NewSession(&aws.Config{
+			Region: aws.String(config.Region),
+		})
+		if err != nil {
+			return nil, err
 		}
-		if item["from_id"] != nil {
-			fromIDStr := *item["from_id"].N
-			finderString := fromIDStr
-			// convert fromIDStr to int64
-			fromID, err := strconv.ParseInt(fromIDStr, 10, 64)
-			if err != nil {
-				log.Printf("failed to parse from_id: %v\n", err)
-			} else {
-				username, err := getUsername(svc, fromID)
-				if err != nil {
-					log.Printf("failed to get username: %v\n", err)
-				} else {
-					finderString = username
-				}
-			}
-			answerString += ": found by " + finderString
-
-			// add to the back of the answers
-			answers += answerString + "\n"
-
-			foundCount++
-		} else {
-			answerString += ": not found"
-
-			// add to the front of the answers
-			answers = answerString + "\n" + answers
+		dynamo := newDynamoStore(dynamodb.New(sess), config.Tables)
+		if err := dynamo.backfillUsernames(); err != nil {
+			return nil, err
 		}
-
+		if err := dynamo.migrateLegacyPuzzles(); err != nil {
+			return nil, err
+		}
+		store = dynamo
+	case "memory":
+		store = newMemoryStore()
+	default:
+		return nil, fmt.Errorf("unknown store %q", kind)
 	}
 
-	if answers == "" {
-		answers = "No answers were added yet"
+	if err := seedTeams(store, config.Teams); err != nil {
+		return nil, err
 	}
-
-	// add found and left count to the beginning
-	answers = "Found: " + strconv.Itoa(foundCount) + " answers\n" +
-		"Left: " + strconv.Itoa(len(result.Items)-foundCount) + " answers\n\n" +
-		answers
-
-	msg := tgbotapi.NewMessage(chatID, answers)
-	bot.Send(msg)
-	return nil
+	return store, nil
 }
 
-func getWaitingCommand(svc *dynamodb.DynamoDB, fromID int64, messageDate int) (string, error) {
-	result, err := svc.GetItem(&dynamodb.GetItemInput{
-		TableName: aws.String("WaitingCommand"),
-		Key: map[string]*dynamodb.AttributeValue{
-			"from_id": {
-				N: aws.String(fmt.Sprint(fromID)),
-			},
-		},
-	})
-	if err != nil {
-		log.Printf("failed to get item: %v\n", err)
-		return "", err
-	}
-	if result.Item == nil {
-		return "", nil
-	}
+func main() {
+	mode := flag.String("mode", "lambda", "run mode: lambda, poll or webhook")
+	storeKind := flag.String("store", "dynamodb", "storage backend for the poll and webhook modes: dynamodb or memory")
+	addr := flag.String("addr", ":8080", "listen address for the webhook mode")
+	webhookURL := flag.String("webhook-url", "", "public URL to register as the webhook, leave empty to keep the current one")
+	flag.Parse()
 
-	// get the command
-	command := ""
-	if result.Item["command"] != nil {
-		command = *result.Item["command"].S
+	config, err := loadConfig()
+	if err != nil {
+		log.Fatalf("failed to load config: %v", err)
 	}
 
-	// get the timestamp
-	timestamp := int64(0)
-	if result.Item["timestamp"] != nil {
-		timestampStr := ""
-		if result.Item["timestamp"] != nil {
-			timestampStr = *result.Item["timestamp"].N
-		}
-		// convert timestampStr to int64
-		t, err := strconv.ParseInt(timestampStr, 10, 64)
-		if err != nil {
-			log.Printf("failed to parse timestamp: %v\n", err)
-		} else {
-			timestamp = t
+	// the token comes from Secrets Manager in Lambda and from BOT_TOKEN elsewhere
+	secretSource := os.Getenv("BOT_TOKEN_SOURCE")
+	if secretSource == "" {
+		secretSource = "env"
+		if *mode == "lambda" {
+			secretSource = "extension"
 		}
 	}
-
-	// remove the item from DynamoDB
-	_, err = svc.DeleteItem(&dynamodb.DeleteItemInput{
-		TableName: aws.String("WaitingCommand"),
-		Key: map[string]*dynamodb.AttributeValue{
-			"from_id": {
-				N: aws.String(fmt.Sprint(fromID)),
-			},
-		},
-	})
+	secrets, err := newSecretProvider(secretSource)
 	if err != nil {
-		log.Printf("failed to delete item: %v\n", err)
-	}
-
-	// check if the timestamp is less than 5 minutes
-	if timestamp+300 < int64(messageDate) {
-		return "", nil
+		log.Fatalf("failed to create secret provider: %v", err)
 	}
 
-	return command, nil
-}
-
-func handler(ctx context.Context, kinesisEvent events.KinesisEvent) error {
-	token, err := getBotToken()
-	if err != nil {
-		log.Fatalf("failed to get bot token: %v", err)
-		return err
+	if *mode == "lambda" {
+		lambdaClients.secrets = secrets
+		lambdaClients.config = config
+		lambda.Start(handler)
+		return
 	}
 
-	bot, err := tgbotapi.NewBotAPI(token)
+	store, err := newStore(*storeKind, config)
 	if err != nil {
-		log.Fatalf("failed to create bot: %v", err)
-		return err
+		log.Fatalf("failed to create store: %v", err)
 	}
 
-	setCommandsMenu(bot)
-
-	// create a DynamoDB client
-	sess := session.Must(session.NewSession(&aws.Config{
-		Region: aws.String("eu-central-1"),
-	}))
-	svc := dynamodb.New(sess)
-
-	for _, record := range kinesisEvent.Records {
-		var update tgbotapi.Update
-		if err := json.Unmarshal([]byte(record.Kinesis.Data), &update); err != nil {
-			log.Println(err)
-			continue
-		}
-
-		if update.Message == nil {
-			continue
-		}
-
-		// send greeting message for a new user
-		if update.Message.NewChatMembers != nil {
-			for _, member := range update.Message.NewChatMembers {
-				greetingMessage := "Hello, " + member.FirstName + "!\n" +
-					"Please register with /register <username> and /team <team>"
-				msg := tgbotapi.NewMessage(update.Message.Chat.ID, greetingMessage)
-				bot.Send(msg)
-			}
-			continue
-		}
-
-		if !update.Message.IsCommand() {
-			waitingCommand, err := getWaitingCommand(svc, update.Message.From.ID, update.Message.Date)
-			if err != nil {
-				log.Printf("failed to get waiting command: %v\n", err)
-				msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Something went wrong. Error: "+err.Error())
-				msg.ReplyMarkup = tgbotapi.NewRemoveKeyboard(true)
-				bot.Send(msg)
-				continue
-			}
-			if waitingCommand == "" {
-				msg := tgbotapi.NewMessage(update.Message.Chat.ID, "I don't understand you")
-				msg.ReplyMarkup = tgbotapi.NewRemoveKeyboard(true)
-				bot.Send(msg)
-				continue
-			}
-			switch waitingCommand {
-			case "register":
-				err := registerUsername(bot, svc, update.Message.From.ID, update.Message.Chat.ID, update.Message.Text)
-				if err != nil {
-					msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Something went wrong. Error: "+err.Error())
-					bot.Send(msg)
-				}
-			case "team":
-				err := registerTeam(bot, svc, update.Message.From.ID, update.Message.Chat.ID, update.Message.Text)
-				if err != nil {
-					msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Something went wrong. Error: "+err.Error())
-					msg.ReplyMarkup = tgbotapi.NewRemoveKeyboard(true)
-					bot.Send(msg)
-				}
-			case "code":
-				err := sendCode(bot, svc, update.Message.From.ID, update.Message.Chat.ID, update.Message.Text)
-				if err != nil {
-					msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Something went wrong. Error: "+err.Error())
-					bot.Send(msg)
-				}
-			case "admin":
-				err := updateAdmin(bot, svc, update.Message.From.ID, update.Message.Chat.ID, update.Message.Text, true)
-				if err != nil {
-					msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Something went wrong. Error: "+err.Error())
-					bot.Send(msg)
-				}
-			case "stopadmin":
-				err := updateAdmin(bot, svc, update.Message.From.ID, update.Message.Chat.ID, update.Message.Text, false)
-				if err != nil {
-					msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Something went wrong. Error: "+err.Error())
-					bot.Send(msg)
-				}
-			case "addcode":
-				err := addCode(bot, svc, update.Message.From.ID, update.Message.Chat.ID, update.Message.Text)
-				if err != nil {
-					msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Something went wrong. Error: "+err.Error())
-					bot.Send(msg)
-				}
-			case "removecode":
-				err := removeCode(bot, svc, update.Message.From.ID, update.Message.Chat.ID, update.Message.Text)
-				if err != nil {
-					msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Something went wrong. Error: "+err.Error())
-					bot.Send(msg)
-				}
-			case "a3":
-				err := answerPair(bot, svc, update.Message.From.ID, update.Message.Chat.ID, update.Message.Text, "PairA")
-				if err != nil {
-					msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Something went wrong. Error: "+err.Error())
-					bot.Send(msg)
-				}
-			case "a3answer":
-				err := addPair(bot, svc, update.Message.From.ID, update.Message.Chat.ID, update.Message.Text, "PairA")
-				if err != nil {
-					msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Something went wrong. Error: "+err.Error())
-					bot.Send(msg)
-				}
-			case "b1":
-				err := answerPair(bot, svc, update.Message.From.ID, update.Message.Chat.ID, update.Message.Text, "PairB")
-				if err != nil {
-					msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Something went wrong. Error: "+err.Error())
-					bot.Send(msg)
-				}
-			case "b1answer":
-				err := addPair(bot, svc, update.Message.From.ID, update.Message.Chat.ID, update.Message.Text, "PairB")
-				if err != nil {
-					msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Something went wrong. Error: "+err.Error())
-					bot.Send(msg)
-				}
-			default:
-				msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Wrong behavior. Cannot handle command "+waitingCommand+". Please contact the admin")
-				bot.Send(msg)
-			}
-
-			continue
-		}
-
-		waitingCommand := ""
-
-		switch update.Message.Command() {
-		case "start":
-			fallthrough
-		case "what":
-			messageString := "I can help you with the following commands:\n" +
-				"/register - set your username\n" +
-				"/team - set your team\n" +
-				"/code - send the code\n" +
-				"/codes - get the codes\n" +
-				"/top - get the top\n" +
-				"/whoami - get your username and team\n" +
-				"/a3 - enter the answer for a3\n" +
-				"/b1 - enter the answer for b1\n" +
-				"/what - show this message\n"
-			if ok, err := isAdmin(svc, update.Message.From.ID); ok && err == nil {
-				messageString += "/admin - become an admin\n" +
-					"/stopadmin - stop being an admin\n" +
-					"/addcode - add a code\n" +
-					"/removecode - remove a code\n" +
-					"/a3answer - add a a3 answer\n" +
-					"/lista3 - list a3\n" +
-					"/b1answer - add a b1 answer\n" +
-					"/listb1 - list b1\n"
-			}
-			msg := tgbotapi.NewMessage(update.Message.Chat.ID, messageString)
-			bot.Send(msg)
-		case "register":
-			waitingCommand = "register"
-			msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Please provide your username")
-			bot.Send(msg)
-		case "team":
-			waitingCommand = "team"
-			teams := [][]tgbotapi.KeyboardButton{
-				{
-					tgbotapi.NewKeyboardButton("A"),
-					tgbotapi.NewKeyboardButton("B"),
-				},
-				{
-					tgbotapi.NewKeyboardButton("C"),
-					tgbotapi.NewKeyboardButton("D"),
-				},
-			}
-			msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Please choose your team")
-			msg.ReplyMarkup = tgbotapi.NewReplyKeyboard(teams...)
-			bot.Send(msg)
-		case "a3":
-			waitingCommand = "a3"
-			msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Please provide the answer")
-			bot.Send(msg)
-		case "b1":
-			waitingCommand = "b1"
-			msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Please provide the answer")
-			bot.Send(msg)
-		case "code":
-			waitingCommand = "code"
-			msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Please provide the code")
-			bot.Send(msg)
-		case "codes":
-			err := listCodes(bot, svc, update.Message.From.ID, update.Message.Chat.ID)
-			if err != nil {
-				msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Something went wrong. Error: "+err.Error())
-				bot.Send(msg)
-			}
-		case "top":
-			err := listTop(bot, svc, update.Message.From.ID, update.Message.Chat.ID)
-			if err != nil {
-				msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Something went wrong. Error: "+err.Error())
-				bot.Send(msg)
-			}
-		case "whoami":
-			username, err := getUsername(svc, update.Message.From.ID)
-			if err == nil {
-				if len(username) > 0 {
-					team, err := getTeam(svc, update.Message.From.ID)
-					if err == nil {
-						messageString := ""
-						if team != "" {
-							messageString = "You are " + username + " from team " + team
-						} else {
-							messageString = "You are " + username
-						}
-						msg := tgbotapi.NewMessage(update.Message.Chat.ID, messageString)
-						bot.Send(msg)
-					} else {
-						msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Something went wrong. Error: "+err.Error())
-						bot.Send(msg)
-					}
-				} else {
-					msg := tgbotapi.NewMessage(update.Message.Chat.ID, "You are not registered")
-					bot.Send(msg)
-				}
-			} else {
-				msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Something went wrong. Error: "+err.Error())
-				bot.Send(msg)
-			}
-		// admin commands
-		case "addcode":
-			waitingCommand = "addcode"
-			msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Please provide the code, room and note separated by -")
-			bot.Send(msg)
-		case "removecode":
-			waitingCommand = "removecode"
-			msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Please provide the code")
-			bot.Send(msg)
-		case "admin":
-			waitingCommand = "admin"
-			msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Please provide the secret")
-			bot.Send(msg)
-		case "stopadmin":
-			waitingCommand = "stopadmin"
-			msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Please provide the secret")
-			bot.Send(msg)
-		case "a3answer":
-			waitingCommand = "a3answer"
-			msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Please provide the answer")
-			bot.Send(msg)
-		case "lista3":
-			err := listPair(bot, svc, update.Message.From.ID, update.Message.Chat.ID, "PairA")
-			if err != nil {
-				msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Something went wrong. Error: "+err.Error())
-				bot.Send(msg)
-			}
-		case "b1answer":
-			waitingCommand = "b1answer"
-			msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Please provide the answer")
-			bot.Send(msg)
-		case "listb1":
-			err := listPair(bot, svc, update.Message.From.ID, update.Message.Chat.ID, "PairB")
-			if err != nil {
-				msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Something went wrong. Error: "+err.Error())
-				bot.Send(msg)
-			}
-		default:
-			msg := tgbotapi.NewMessage(update.Message.Chat.ID, "I don't know that command")
-			bot.Send(msg)
-		}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-		if waitingCommand != "" {
-			// save the command, fromID and timestamp to DynamoDB
-			_, err := svc.PutItem(&dynamodb.PutItemInput{
-				TableName: aws.String("WaitingCommand"),
-				Item: map[string]*dynamodb.AttributeValue{
-					"from_id": {
-						N: aws.String(fmt.Sprint(update.Message.From.ID)),
-					},
-					"command": {
-						S: aws.String(waitingCommand),
-					},
-					"timestamp": {
-						N: aws.String(fmt.Sprint(update.Message.Date)),
-					},
-				},
-			})
-			if err != nil {
-				log.Printf("failed to put item: %v\n", err)
-			}
-		}
+	switch *mode {
+	case "poll":
+		err = runPolling(ctx, secrets, store, config)
+	case "webhook":
+		err = runWebhook(ctx, secrets, store, config, *addr, *webhookURL, os.Getenv("WEBHOOK_SECRET"))
+	default:
+		err = fmt.Errorf("unknown mode %q", *mode)
+	}
+	if err != nil {
+		log.Fatalf("failed to run in %s mode: %v", *mode, err)
 	}
-
-	return nil
-}
-
-func main() {
-	lambda.Start(handler)
 }
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// matching levels of a puzzle set, from the strictest
+const (
+	// matchingExact only ignores case and surrounding spaces
+	matchingExact = "exact"
+	// matchingNormal compares answers after normalizeAnswer
+	matchingNormal = "normal"
+	// matchingFuzzy also forgives up to Tolerance typos
+	matchingFuzzy = "fuzzy"
+)
+
+// defaultTolerance is the number of typos forgiven when an admin makes a
+// set fuzzy without saying how many
+const defaultTolerance = 1
+
+// matching returns the matching level of the set, sets created before
+// levels existed use matchingNormal
+func (s *PuzzleSet) matching() string {
+	if s.Matching == "" {
+		return matchingNormal
+	}
+	return s.Matching
+}
+
+// describeMatching explains to admins how answers of the set are compared
+func (s *PuzzleSet) describeMatching() string {
+	switch s.matching() {
+	case matchingExact:
+		return "exact, ignoring case"
+	case matchingFuzzy:
+		return "fuzzy, ignoring case, accents, punctuation and extra spaces, up to " + strconv.Itoa(s.Tolerance) + " typos"
+	default:
+		return "normal, ignoring case, accents, punctuation and extra spaces"
+	}
+}
+
+// normalizeAnswer brings an answer to the form in which it is compared:
+// compatibility characters are unified (NFKC), case, accents and ё are
+// folded, punctuation becomes a space and runs of spaces are collapsed
+func normalizeAnswer(answer string) string {
+	answer = strings.ToLower(norm.NFKC.String(answer))
+
+	folded := strings.Builder{}
+	for _, r := range answer {
+		switch {
+		case r == 'ё':
+			folded.WriteRune('е')
+		case r == 'й':
+			// a separate letter, not и with an accent
+			folded.WriteRune(r)
+		case unicode.IsPunct(r) || unicode.IsSymbol(r):
+			folded.WriteRune(' ')
+		default:
+			// drop the accents of the decomposed letter
+			for _, part := range norm.NFD.String(string(r)) {
+				if !unicode.Is(unicode.Mn, part) {
+					folded.WriteRune(part)
+				}
+			}
+		}
+	}
+	return strings.Join(strings.Fields(folded.String()), " ")
+}
+
+// compareForm is the form of the answer compared under the matching level
+func compareForm(answer string, matching string) string {
+	if matching == matchingExact {
+		return strings.ToLower(strings.TrimSpace(answer))
+	}
+	return normalizeAnswer(answer)
+}
+
+// spellings are the accepted spellings of the answer
+func (a *PuzzleAnswer) spellings() []string {
+	return append([]string{a.Answer}, a.Aliases...)
+}
+
+// findSpelling returns the answer with a spelling equal to the guess under
+// the matching level, typos are not forgiven
+func findSpelling(answers []*PuzzleAnswer, guess string, matching string) *PuzzleAnswer {
+	guess = compareForm(guess, matching)
+	for _, answer := range answers {
+		for _, spelling := range answer.spellings() {
+			if compareForm(spelling, matching) == guess {
+				return answer
+			}
+		}
+	}
+	return nil
+}
+
+// matchAnswer returns the answer of the set the guess stands for, or nil.
+// Fuzzy sets accept the closest answer within the typo limit, preferring
+// answers nobody has found yet.
+func matchAnswer(set *PuzzleSet, answers []*PuzzleAnswer, guess string) *PuzzleAnswer {
+	if answer := findSpelling(answers, guess, set.matching()); answer != nil {
+		return answer
+	}
+	if set.matching() != matchingFuzzy {
+		return nil
+	}
+
+	guess = normalizeAnswer(guess)
+	var best *PuzzleAnswer
+	bestDistance := 0
+	for _, answer := range answers {
+		for _, spelling := range answer.spellings() {
+			spelling = normalizeAnswer(spelling)
+			distance := levenshtein(spelling, guess)
+			if distance > typoLimit(spelling, set.Tolerance) {
+				continue
+			}
+			if best == nil || distance < bestDistance ||
+				(distance == bestDistance && best.FromID != 0 && answer.FromID == 0) {
+				best = answer
+				bestDistance = distance
+			}
+		}
+	}
+	return best
+}
+
+// typoLimit is the number of typos forgiven in the answer: at most the
+// tolerance and at most one per four letters, so that short answers are
+// not matched by unrelated words
+func typoLimit(answer string, tolerance int) int {
+	limit := len([]rune(answer)) / 4
+	if tolerance < limit {
+		return tolerance
+	}
+	return limit
+}
+
+// levenshtein counts the insertions, deletions and substitutions of letters
+// needed to turn a into b
+func levenshtein(a string, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = previous[j-1] + cost
+			if previous[j]+1 < current[j] {
+				current[j] = previous[j] + 1
+			}
+			if current[j-1]+1 < current[j] {
+				current[j] = current[j-1] + 1
+			}
+		}
+		previous, current = current, previous
+	}
+	return previous[len(rb)]
+}
+
+func validateMatching(a *App, matching string) (string, error) {
+	switch strings.ToLower(matching) {
+	case matchingExact, matchingNormal, matchingFuzzy:
+		return "", nil
+	}
+	return "Please provide one of '" + matchingExact + "', '" + matchingNormal + "' or '" + matchingFuzzy + "'", nil
+}
+
+func validateTolerance(a *App, tolerance string) (string, error) {
+	value, err := strconv.Atoi(tolerance)
+	if err != nil || value < 0 || value > 3 {
+		return "Please provide a number of typos from 0 to 3", nil
+	}
+	return "", nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import "testing"
+
+func TestNormalizeAnswer(t *testing.T) {
+	tests := []struct {
+		answer string
+		want   string
+	}{
+		{"Ёлка", "елка"},
+		{"ёлка", "елка"},
+		{"ЕЛКА", "елка"},
+		{"Йогурт", "йогурт"},
+		{"  Hello,   World!! ", "hello world"},
+		{"rock-n-roll", "rock n roll"},
+		{"Café", "cafe"},
+		{"ＡＢＣ１２", "abc12"},
+		{"«Война и мир»", "война и мир"},
+		{"...", ""},
+	}
+	for _, test := range tests {
+		if got := normalizeAnswer(test.answer); got != test.want {
+			t.Errorf("normalizeAnswer(%q) = %q, want %q", test.answer, got, test.want)
+		}
+	}
+}
+
+func TestTypoLimit(t *testing.T) {
+	tests := []struct {
+		answer    string
+		tolerance int
+		want      int
+	}{
+		{"кот", 3, 0},
+		{"рыба", 3, 1},
+		{"паровоз", 3, 1},
+		{"паровозик", 3, 2},
+		{"паровозик", 1, 1},
+		{"паровозик", 0, 0},
+	}
+	for _, test := range tests {
+		if got := typoLimit(test.answer, test.tolerance); got != test.want {
+			t.Errorf("typoLimit(%q, %d) = %d, want %d", test.answer, test.tolerance, got, test.want)
+		}
+	}
+}
+
+func TestLevenshtein(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"елка", "елка", 0},
+		{"елка", "елки", 1},
+		{"елка", "ела", 1},
+		{"елка", "елкаа", 1},
+		{"kitten", "sitting", 3},
+	}
+	for _, test := range tests {
+		if got := levenshtein(test.a, test.b); got != test.want {
+			t.Errorf("levenshtein(%q, %q) = %d, want %d", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestMatchAnswer(t *testing.T) {
+	answers := []*PuzzleAnswer{
+		{Answer: "Ёлка"},
+		{Answer: "Йод"},
+		{Answer: "кот"},
+		{Answer: "Война и мир", Aliases: []string{"War and Peace"}},
+		{Answer: "bread", FromID: 1},
+		{Answer: "break"},
+	}
+	exact := &PuzzleSet{Name: "exact", Matching: matchingExact}
+	normal := &PuzzleSet{Name: "normal", Matching: matchingNormal}
+	legacy := &PuzzleSet{Name: "legacy"}
+	fuzzy := &PuzzleSet{Name: "fuzzy", Matching: matchingFuzzy, Tolerance: 2}
+
+	tests := []struct {
+		set   *PuzzleSet
+		guess string
+		want  string
+	}{
+		{exact, " ёлка ", "Ёлка"},
+		{exact, "елка", ""},
+		{exact, "война и мир!", ""},
+		{normal, "елка", "Ёлка"},
+		{normal, "ЁЛКА!", "Ёлка"},
+		{normal, "иод", ""},
+		{normal, "война,   и мир", "Война и мир"},
+		{normal, "war and peace", "Война и мир"},
+		{normal, "елки", ""},
+		{legacy, "елка", "Ёлка"},
+		{fuzzy, "елки", "Ёлка"},
+		{fuzzy, "ёлочка", ""},
+		{fuzzy, "война и мор", "Война и мир"},
+		{fuzzy, "war and pace", "Война и мир"},
+		// short answers are matched exactly even with a high tolerance
+		{fuzzy, "кит", ""},
+		{fuzzy, "йот", ""},
+		// both are one typo away, the one nobody found yet wins
+		{fuzzy, "brea", "break"},
+		// an exact spelling wins over an unfound answer
+		{fuzzy, "bread", "bread"},
+	}
+	for _, test := range tests {
+		got := ""
+		if answer := matchAnswer(test.set, answers, test.guess); answer != nil {
+			got = answer.Answer
+		}
+		if got != test.want {
+			t.Errorf("matchAnswer(%s, %q) = %q, want %q", test.set.Name, test.guess, got, test.want)
+		}
+	}
+}
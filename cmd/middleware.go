@@ -0,0 +1,110 @@
+package main
+
+import "log"
+
+// HandlerFunc runs a command with its request filled in
+type HandlerFunc func(a *App, req *Request) error
+
+// Middleware wraps a handler with a check or with loading of data the
+// handler needs. It either calls next or replies to the user and stops.
+type Middleware func(next HandlerFunc) HandlerFunc
+
+func defaultMiddlewares() []Middleware {
+	return []Middleware{
+		announceGameEnd,
+		loadUser,
+		requireChatType,
+		requireRole,
+		requireActiveGame,
+	}
+}
+
+// chain applies the middlewares so that the first one runs first
+func chain(handler HandlerFunc, middlewares ...Middleware) HandlerFunc {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// announceGameEnd sends the final standings once the scheduled end of the
+// game has passed. It runs after the handler, so the user who happens to send
+// the first command after the end gets the reply before the broadcast.
+func announceGameEnd(next HandlerFunc) HandlerFunc {
+	return func(a *App, req *Request) error {
+		if err := next(a, req); err != nil {
+			return err
+		}
+
+		game, err := a.store.GetGame()
+		if err != nil {
+			log.Printf("failed to get game: %v\n", err)
+			return nil
+		}
+		if game != nil && game.over(req.Date) && game.AnnouncedAt == 0 {
+			// the command itself succeeded, the next one tries again
+			if _, err := announceGame(a, game, req.Date); err != nil {
+				log.Printf("failed to announce game end: %v\n", err)
+			}
+		}
+		return nil
+	}
+}
+
+// loadUser fetches the profile of the sender once per update. Request.User
+// stays nil for users who have not registered yet.
+func loadUser(next HandlerFunc) HandlerFunc {
+	return func(a *App, req *Request) error {
+		if req.User == nil {
+			user, err := a.store.GetUser(req.FromID)
+			if err != nil {
+				return err
+			}
+			req.User = user
+		}
+		return next(a, req)
+	}
+}
+
+func requireChatType(next HandlerFunc) HandlerFunc {
+	return func(a *App, req *Request) error {
+		if !req.Command.allowsChatType(req.ChatType) {
+			a.notify(req, "Please send /"+req.Command.Name+" in a private chat with me")
+			return nil
+		}
+		return next(a, req)
+	}
+}
+
+func requireRole(next HandlerFunc) HandlerFunc {
+	return func(a *App, req *Request) error {
+		switch {
+		case req.Command.Role >= RoleRegistered && req.User == nil:
+			a.notify(req, "Please register first")
+			return nil
+		case req.Command.Role >= RoleAdmin && !req.User.Admin:
+			a.notify(req, "You are not an admin")
+			return nil
+		}
+		return next(a, req)
+	}
+}
+
+// requireActiveGame refuses DuringGame commands outside the running game
+func requireActiveGame(next HandlerFunc) HandlerFunc {
+	return func(a *App, req *Request) error {
+		if !req.Command.DuringGame {
+			return next(a, req)
+		}
+
+		game, err := a.store.GetGame()
+		if err != nil {
+			return err
+		}
+		if reason := gameClosed(game, req.Date); reason != "" {
+			a.notify(req, reason)
+			return nil
+		}
+		return next(a, req)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// runPolling receives updates with getUpdates long polling instead of the
+// API Gateway -> Kinesis -> Lambda chain. It returns when ctx is cancelled.
+func runPolling(ctx context.Context, secrets SecretProvider, store Store, config *Config) error {
+	bot, err := newBot(secrets)
+	if err != nil {
+		return err
+	}
+
+	setCommandsMenu(bot, newCommands())
+
+	// getUpdates does not work while a webhook is set
+	if _, err := bot.Request(tgbotapi.DeleteWebhookConfig{}); err != nil {
+		log.Printf("failed to delete webhook: %v\n", err)
+		return err
+	}
+
+	app := newApp(bot, store, config, time.Now)
+
+	updateConfig := tgbotapi.NewUpdate(0)
+	updateConfig.Timeout = 60
+	updates := bot.GetUpdatesChan(updateConfig)
+
+	log.Printf("polling updates for @%s\n", bot.Self.UserName)
+
+	for {
+		select {
+		case <-ctx.Done():
+			bot.StopReceivingUpdates()
+			return nil
+		case update, ok := <-updates:
+			if !ok {
+				return nil
+			}
+			processWithRetries(ctx, app, update)
+		}
+	}
+}
+
+// getUpdates has already moved past the update, so retriable failures are
+// retried here instead of by Telegram
+func processWithRetries(ctx context.Context, app *App, update tgbotapi.Update) {
+	const attempts = 3
+	for attempt := 1; ; attempt++ {
+		err := app.ProcessUpdate(ctx, update)
+		if err == nil {
+			return
+		}
+		log.Printf("failed to process update %d (attempt %d): %v\n", update.UpdateID, attempt, err)
+		if !isRetriable(err) || attempt == attempts {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Duration(attempt) * time.Second):
+		}
+	}
+}
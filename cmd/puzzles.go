@@ -0,0 +1,397 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// maxPuzzleSetNameLength keeps set names short enough to type after /answer
+const maxPuzzleSetNameLength = 32
+
+// puzzleSetName is the form in which set names are stored, so that /answer
+// A3 and /answer a3 refer to the same set
+func puzzleSetName(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+func validatePuzzleSet(a *App, name string) (string, error) {
+	set, err := a.store.GetPuzzleSet(puzzleSetName(name))
+	if err != nil {
+		log.Printf("failed to get puzzle set: %v\n", err)
+		return "", err
+	}
+	if set != nil {
+		return "", nil
+	}
+
+	sets, err := sortedPuzzleSets(a.store)
+	if err != nil {
+		return "", err
+	}
+	if len(sets) == 0 {
+		return "No puzzles were added yet", nil
+	}
+	validSets := ""
+	for _, valid := range sets {
+		validSets += "'" + valid.Name + "' "
+	}
+	return "Please provide a valid puzzle. Valid puzzles are " + validSets, nil
+}
+
+// validateNewPuzzleSet checks the name of a set that is about to be created
+func validateNewPuzzleSet(a *App, name string) (string, error) {
+	name = puzzleSetName(name)
+	if len(name) > maxPuzzleSetNameLength {
+		return "Puzzle name is too long, the limit is " + strconv.Itoa(maxPuzzleSetNameLength) + " characters", nil
+	}
+	if strings.ContainsAny(name, " \t\n") {
+		return "Puzzle name cannot contain spaces", nil
+	}
+
+	set, err := a.store.GetPuzzleSet(name)
+	if err != nil {
+		log.Printf("failed to get puzzle set: %v\n", err)
+		return "", err
+	}
+	if set != nil {
+		return "Puzzle " + name + " already exists", nil
+	}
+	return "", nil
+}
+
+// sortedPuzzleSets returns all puzzle sets ordered by name
+func sortedPuzzleSets(store Store) ([]*PuzzleSet, error) {
+	sets, err := store.ListPuzzleSets()
+	if err != nil {
+		log.Printf("failed to list puzzle sets: %v\n", err)
+		return nil, err
+	}
+	sort.Slice(sets, func(i, j int) bool {
+		return sets[i].Name < sets[j].Name
+	})
+	return sets, nil
+}
+
+// getPuzzleSet loads the set from the "set" field, replying when it does
+// not exist anymore
+func getPuzzleSet(a *App, req *Request) (*PuzzleSet, error) {
+	name := puzzleSetName(req.Fields["set"])
+	set, err := a.store.GetPuzzleSet(name)
+	if err != nil {
+		log.Printf("failed to get puzzle set: %v\n", err)
+		return nil, err
+	}
+	if set == nil {
+		msg := tgbotapi.NewMessage(req.ChatID, "Puzzle "+name+" does not exist")
+		a.bot.Send(msg)
+	}
+	return set, nil
+}
+
+func allFound(answers []*PuzzleAnswer) bool {
+	for _, answer := range answers {
+		if answer.FromID == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func allAnswersFound(store Store, set string) (bool, error) {
+	answers, err := store.ListAnswers(set)
+	if err != nil {
+		log.Printf("failed to list answers: %v\n", err)
+		return false, err
+	}
+	return allFound(answers), nil
+}
+
+func answerPair(a *App, req *Request) error {
+	set, err := getPuzzleSet(a, req)
+	if err != nil || set == nil {
+		return err
+	}
+
+	answers, err := a.store.ListAnswers(set.Name)
+	if err != nil {
+		log.Printf("failed to list answers: %v\n", err)
+		return err
+	}
+
+	// check if all the answers found
+	if allFound(answers) {
+		msg := tgbotapi.NewMessage(req.ChatID, "All answers of "+set.Name+" were found")
+		a.bot.Send(msg)
+		return nil
+	}
+
+	match := matchAnswer(set, answers, req.Fields["answer"])
+	if match == nil {
+		msg := tgbotapi.NewMessage(req.ChatID, "Wrong answer")
+		a.bot.Send(msg)
+		return nil
+	}
+
+	// claim the answer, the first finder keeps it
+	answer, claimed, err := a.store.ClaimAnswer(set.Name, match.Answer, req.FromID, req.Date)
+	if err != nil {
+		log.Printf("failed to claim answer: %v\n", err)
+		return err
+	}
+	if answer == nil {
+		msg := tgbotapi.NewMessage(req.ChatID, "Wrong answer")
+		a.bot.Send(msg)
+		return nil
+	}
+	if !claimed {
+		messageString := ""
+		if answer.FromID == req.FromID {
+			messageString = "You have already found the answer " + answer.Answer
+		} else {
+			finder := "someone else"
+			users, err := a.store.GetUsers([]int64{answer.FromID})
+			if err != nil {
+				log.Printf("failed to get users: %v\n", err)
+				return err
+			}
+			if user, ok := users[answer.FromID]; ok {
+				finder = user.Username
+			}
+			messageString = "Answer " + answer.Answer + " was already found by " + finder
+		}
+		msg := tgbotapi.NewMessage(req.ChatID, messageString)
+		a.bot.Send(msg)
+		return nil
+	}
+
+	messageString := "Congratulations, " + req.User.Username + "! You found the answer " + answer.Answer
+
+	// check if all the answers found. The answer is claimed already, so a
+	// failure only leaves out the note: a retry could not congratulate.
+	found, err := allAnswersFound(a.store, set.Name)
+	if err != nil {
+		log.Printf("failed to check the answers of %s: %v\n", set.Name, err)
+	}
+	if found {
+		messageString += "\nAll answers of " + set.Name + " were found"
+	}
+
+	msg := tgbotapi.NewMessage(req.ChatID, messageString)
+	a.bot.Send(msg)
+	return nil
+}
+
+func addPuzzleSet(a *App, req *Request) error {
+	set := &PuzzleSet{
+		Name:     puzzleSetName(req.Fields["name"]),
+		Matching: matchingNormal,
+	}
+	if err := a.store.PutPuzzleSet(set); err != nil {
+		log.Printf("failed to put puzzle set: %v\n", err)
+		return err
+	}
+
+	msg := tgbotapi.NewMessage(req.ChatID, "Puzzle "+set.Name+" was added. Add its answers with /addanswer "+set.Name+" <answer>")
+	a.bot.Send(msg)
+	return nil
+}
+
+func addPair(a *App, req *Request) error {
+	set, err := getPuzzleSet(a, req)
+	if err != nil || set == nil {
+		return err
+	}
+	commandArgument := req.Fields["answer"]
+
+	// to lower
+	commandArgument = strings.ToLower(commandArgument)
+	// trim spaces
+	commandArgument = strings.TrimSpace(commandArgument)
+
+	answers, err := a.store.ListAnswers(set.Name)
+	if err != nil {
+		log.Printf("failed to list answers: %v\n", err)
+		return err
+	}
+	if answer := findSpelling(answers, commandArgument, set.matching()); answer != nil {
+		msg := tgbotapi.NewMessage(req.ChatID, "Answer "+commandArgument+" already matches the answer "+answer.Answer)
+		a.bot.Send(msg)
+		return nil
+	}
+
+	// create a new item
+	if err := a.store.PutAnswer(set.Name, commandArgument); err != nil {
+		log.Printf("failed to put answer: %v\n", err)
+		return err
+	}
+
+	msg := tgbotapi.NewMessage(req.ChatID, "Answer "+commandArgument+" was added to "+set.Name)
+	a.bot.Send(msg)
+	return nil
+}
+
+// addAlias accepts another spelling for an existing answer
+func addAlias(a *App, req *Request) error {
+	set, err := getPuzzleSet(a, req)
+	if err != nil || set == nil {
+		return err
+	}
+	alias := strings.ToLower(strings.TrimSpace(req.Fields["alias"]))
+
+	answers, err := a.store.ListAnswers(set.Name)
+	if err != nil {
+		log.Printf("failed to list answers: %v\n", err)
+		return err
+	}
+	answer := findSpelling(answers, req.Fields["answer"], set.matching())
+	if answer == nil {
+		msg := tgbotapi.NewMessage(req.ChatID, "Answer "+req.Fields["answer"]+" does not exist in "+set.Name)
+		a.bot.Send(msg)
+		return nil
+	}
+	if existing := findSpelling(answers, alias, set.matching()); existing != nil {
+		msg := tgbotapi.NewMessage(req.ChatID, "Alias "+alias+" already matches the answer "+existing.Answer)
+		a.bot.Send(msg)
+		return nil
+	}
+
+	if err := a.store.AddAlias(set.Name, answer.Answer, alias); err != nil {
+		log.Printf("failed to add alias: %v\n", err)
+		return err
+	}
+
+	msg := tgbotapi.NewMessage(req.ChatID, "Alias "+alias+" was added to the answer "+answer.Answer)
+	a.bot.Send(msg)
+	return nil
+}
+
+// setPuzzleMatching changes how strictly answers of the set are compared
+func setPuzzleMatching(a *App, req *Request) error {
+	set, err := getPuzzleSet(a, req)
+	if err != nil || set == nil {
+		return err
+	}
+
+	set.Matching = strings.ToLower(req.Fields["matching"])
+	set.Tolerance = 0
+	if set.Matching == matchingFuzzy {
+		set.Tolerance = defaultTolerance
+		if req.Fields["tolerance"] != "" {
+			set.Tolerance, _ = strconv.Atoi(req.Fields["tolerance"])
+		}
+	}
+	if err := a.store.PutPuzzleSet(set); err != nil {
+		log.Printf("failed to put puzzle set: %v\n", err)
+		return err
+	}
+
+	msg := tgbotapi.NewMessage(req.ChatID, "Answers of "+set.Name+" are now matched: "+set.describeMatching())
+	a.bot.Send(msg)
+	return nil
+}
+
+func listPair(a *App, req *Request) error {
+	set, err := getPuzzleSet(a, req)
+	if err != nil || set == nil {
+		return err
+	}
+
+	// get all answers
+	allAnswers, err := a.store.ListAnswers(set.Name)
+	if err != nil {
+		log.Printf("failed to list answers: %v\n", err)
+		return err
+	}
+
+	fromIDs := []int64{}
+	for _, answer := range allAnswers {
+		if answer.FromID != 0 {
+			fromIDs = append(fromIDs, answer.FromID)
+		}
+	}
+	users, err := a.store.GetUsers(fromIDs)
+	if err != nil {
+		log.Printf("failed to get users: %v\n", err)
+		return err
+	}
+
+	answers := ""
+	foundCount := 0
+	for _, answer := range allAnswers {
+		answerString := answer.Answer
+		if len(answer.Aliases) > 0 {
+			answerString += " (" + strings.Join(answer.Aliases, ", ") + ")"
+		}
+		if answer.FromID != 0 {
+			finderString := fmt.Sprint(answer.FromID)
+			if user, ok := users[answer.FromID]; ok {
+				finderString = user.Username
+			}
+			answerString += ": found by " + finderString
+
+			// add to the back of the answers
+			answers += answerString + "\n"
+
+			foundCount++
+		} else {
+			answerString += ": not found"
+
+			// add to the front of the answers
+			answers = answerString + "\n" + answers
+		}
+
+	}
+
+	if answers == "" {
+		answers = "No answers were added yet"
+	}
+
+	// add found and left count to the beginning
+	answers = "Matching: " + set.describeMatching() + "\n" +
+		"Found: " + strconv.Itoa(foundCount) + " answers\n" +
+		"Left: " + strconv.Itoa(len(allAnswers)-foundCount) + " answers\n\n" +
+		answers
+
+	msg := tgbotapi.NewMessage(req.ChatID, answers)
+	a.bot.Send(msg)
+	return nil
+}
+
+// listPuzzleSets shows every puzzle with how many of its answers were found
+func listPuzzleSets(a *App, req *Request) error {
+	sets, err := sortedPuzzleSets(a.store)
+	if err != nil {
+		return err
+	}
+	if len(sets) == 0 {
+		msg := tgbotapi.NewMessage(req.ChatID, "No puzzles were added yet")
+		a.bot.Send(msg)
+		return nil
+	}
+
+	messageString := "Puzzles:\n"
+	for _, set := range sets {
+		answers, err := a.store.ListAnswers(set.Name)
+		if err != nil {
+			log.Printf("failed to list answers: %v\n", err)
+			return err
+		}
+		foundCount := 0
+		for _, answer := range answers {
+			if answer.FromID != 0 {
+				foundCount++
+			}
+		}
+		messageString += set.Name + ": " + strconv.Itoa(foundCount) + " of " + strconv.Itoa(len(answers)) + " answers found\n"
+	}
+	messageString += "\nSend an answer with /answer <puzzle> <answer>"
+
+	msg := tgbotapi.NewMessage(req.ChatID, messageString)
+	a.bot.Send(msg)
+	return nil
+}
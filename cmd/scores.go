@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+)
+
+// defaultCodePoints is what a code is worth unless the admin says otherwise
+const defaultCodePoints = 1
+
+// formatPoints shows points with their sign, e.g. "+3" or "-2"
+func formatPoints(points int) string {
+	return fmt.Sprintf("%+d", points)
+}
+
+// score is what the found code is worth to its finder
+func (c *DozorCode) score() int {
+	return c.Points + c.Bonus
+}
+
+// awardFirstFind gives the first find bonus to the code just claimed when
+// no other code of its room was found before it. Penalty codes do not open
+// a room. A failure is only logged: the code is claimed already, so a retry
+// of the update could not congratulate the finder anymore.
+func awardFirstFind(a *App, dozorCode *DozorCode) bool {
+	if a.config.FirstFindBonus <= 0 || dozorCode.Points < 0 {
+		return false
+	}
+
+	opened, err := a.store.OpenRoom(dozorCode.Room, dozorCode.Code, a.config.FirstFindBonus)
+	if err != nil {
+		log.Printf("failed to open room: %v\n", err)
+		return false
+	}
+	if opened {
+		dozorCode.Bonus = a.config.FirstFindBonus
+	}
+	return opened
+}
+
+func validatePoints(a *App, points string) (string, error) {
+	if _, err := strconv.Atoi(points); err != nil {
+		return "Please provide the points as a whole number, negative for a penalty code", nil
+	}
+	return "", nil
+}
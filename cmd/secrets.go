@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// SecretProvider returns the bot token from wherever it is kept.
+type SecretProvider interface {
+	GetSecret() (string, error)
+}
+
+type SecretResponse struct {
+	SecretString string `json:"SecretString"`
+}
+
+// extensionSecretProvider reads a secret through the AWS Parameters and
+// Secrets Lambda Extension running next to the function.
+type extensionSecretProvider struct {
+	client   *http.Client
+	endpoint string
+	secretID string
+}
+
+func newExtensionSecretProvider(secretID string) *extensionSecretProvider {
+	port := os.Getenv("PARAMETERS_SECRETS_EXTENSION_HTTP_PORT")
+	if port == "" {
+		port = "2773"
+	}
+	return &extensionSecretProvider{
+		client:   &http.Client{Timeout: 10 * time.Second},
+		endpoint: "http://localhost:" + port + "/secretsmanager/get",
+		secretID: secretID,
+	}
+}
+
+func (p *extensionSecretProvider) GetSecret() (string, error) {
+	req, err := http.NewRequest(http.MethodGet, p.endpoint+"?secretId="+url.QueryEscape(p.secretID), nil)
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Add("X-Aws-Parameters-Secrets-Token", os.Getenv("AWS_SESSION_TOKEN"))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("secret %s: extension responded with %s: %s", p.secretID, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var secret SecretResponse
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return "", err
+	}
+	if secret.SecretString == "" {
+		return "", fmt.Errorf("secret %s is empty", p.secretID)
+	}
+
+	return secret.SecretString, nil
+}
+
+// envSecretProvider reads a secret from an environment variable.
+type envSecretProvider struct {
+	name string
+}
+
+func (p *envSecretProvider) GetSecret() (string, error) {
+	secret := strings.TrimSpace(os.Getenv(p.name))
+	if secret == "" {
+		return "", fmt.Errorf("%s is not set", p.name)
+	}
+	return secret, nil
+}
+
+// fileSecretProvider reads a secret from a file, e.g. a mounted Docker or
+// Kubernetes secret.
+type fileSecretProvider struct {
+	path string
+}
+
+func (p *fileSecretProvider) GetSecret() (string, error) {
+	content, err := os.ReadFile(p.path)
+	if err != nil {
+		return "", err
+	}
+	secret := strings.TrimSpace(string(content))
+	if secret == "" {
+		return "", fmt.Errorf("secret file %s is empty", p.path)
+	}
+	return secret, nil
+}
+
+// newSecretProvider creates the provider of the bot token for the given
+// source: extension, env or file.
+func newSecretProvider(source string) (SecretProvider, error) {
+	switch source {
+	case "extension":
+		secretID := os.Getenv("BOT_TOKEN_SECRET_ID")
+		if secretID == "" {
+			secretID = "BotToken"
+		}
+		return newExtensionSecretProvider(secretID), nil
+	case "env":
+		return &envSecretProvider{name: "BOT_TOKEN"}, nil
+	case "file":
+		path := os.Getenv("BOT_TOKEN_FILE")
+		if path == "" {
+			return nil, errors.New("BOT_TOKEN_FILE is not set")
+		}
+		return &fileSecretProvider{path: path}, nil
+	default:
+		return nil, fmt.Errorf("unknown secret source %q", source)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// TeamStanding is what the players of a team found during the game
+type TeamStanding struct {
+	Team  string
+	Label string
+	Codes int
+	// Points are the scores of the found codes, penalties included
+	Points  int
+	Answers int
+	// CodesByRoom counts found codes per room
+	CodesByRoom map[string]int
+	// LastFoundAt is the unix time of the latest find
+	LastFoundAt int64
+}
+
+// total counts the code points and one point per puzzle answer
+func (s *TeamStanding) total() int {
+	return s.Points + s.Answers
+}
+
+func (s *TeamStanding) found(foundAt int64) {
+	if foundAt > s.LastFoundAt {
+		s.LastFoundAt = foundAt
+	}
+}
+
+// teamStandings aggregates codes and puzzle answers by the team of their
+// finder. Every team is listed, finds of players without a team are not
+// counted. The team with the most points comes first; ties go to the team
+// that got there first.
+func teamStandings(a *App) ([]*TeamStanding, error) {
+	teams, err := a.store.ListTeams()
+	if err != nil {
+		log.Printf("failed to list teams: %v\n", err)
+		return nil, err
+	}
+	users, err := a.store.ListUsers()
+	if err != nil {
+		log.Printf("failed to list users: %v\n", err)
+		return nil, err
+	}
+
+	standingsByTeam := make(map[string]*TeamStanding)
+	for _, team := range teams {
+		standingsByTeam[team.Name] = &TeamStanding{
+			Team:        team.Name,
+			Label:       team.label(),
+			CodesByRoom: make(map[string]int),
+		}
+	}
+	teamByUser := make(map[int64]*TeamStanding)
+	for _, user := range users {
+		if standing, ok := standingsByTeam[user.Team]; ok {
+			teamByUser[user.FromID] = standing
+		}
+	}
+
+	allCodes, err := a.store.ListCodes()
+	if err != nil {
+		log.Printf("failed to list codes: %v\n", err)
+		return nil, err
+	}
+	for _, dozorCode := range allCodes {
+		standing, ok := teamByUser[dozorCode.FromID]
+		if !ok {
+			continue
+		}
+		standing.Codes++
+		standing.Points += dozorCode.score()
+		standing.CodesByRoom[dozorCode.Room]++
+		standing.found(dozorCode.FoundAt)
+	}
+
+	sets, err := a.store.ListPuzzleSets()
+	if err != nil {
+		log.Printf("failed to list puzzle sets: %v\n", err)
+		return nil, err
+	}
+	for _, set := range sets {
+		answers, err := a.store.ListAnswers(set.Name)
+		if err != nil {
+			log.Printf("failed to list answers: %v\n", err)
+			return nil, err
+		}
+		for _, answer := range answers {
+			standing, ok := teamByUser[answer.FromID]
+			if !ok {
+				continue
+			}
+			standing.Answers++
+			standing.found(answer.FoundAt)
+		}
+	}
+
+	standings := make([]*TeamStanding, 0, len(standingsByTeam))
+	for _, standing := range standingsByTeam {
+		standings = append(standings, standing)
+	}
+	sort.Slice(standings, func(i, j int) bool {
+		if standings[i].total() != standings[j].total() {
+			return standings[i].total() > standings[j].total()
+		}
+		if standings[i].LastFoundAt != standings[j].LastFoundAt {
+			return foundEarlier(standings[i].LastFoundAt, standings[j].LastFoundAt)
+		}
+		return standings[i].Team < standings[j].Team
+	})
+	return standings, nil
+}
+
+// foundEarlier reports whether a find at a came before a find at b. Finds
+// without a recorded time (0) are not known to be early, so they
+// come after any known time.
+func foundEarlier(a int64, b int64) bool {
+	if a == 0 || b == 0 {
+		return b == 0 && a != 0
+	}
+	return a < b
+}
+
+func formatFoundAt(foundAt int64) string {
+	return time.Unix(foundAt, 0).UTC().Format("Jan 2 15:04 UTC")
+}
+
+func formatTeamStandings(standings []*TeamStanding) string {
+	top := ""
+	for i, standing := range standings {
+		top += strconv.Itoa(i+1) + ". " + standing.Label + " " + strconv.Itoa(standing.total()) +
+			" (codes: " + strconv.Itoa(standing.Codes) + " for " + formatPoints(standing.Points) + ", answers: " + strconv.Itoa(standing.Answers) + ")"
+		if standing.LastFoundAt != 0 {
+			top += ", last find " + formatFoundAt(standing.LastFoundAt)
+		}
+		top += "\n"
+
+		rooms := make([]string, 0, len(standing.CodesByRoom))
+		for room := range standing.CodesByRoom {
+			rooms = append(rooms, room)
+		}
+		sort.Strings(rooms)
+		for _, room := range rooms {
+			top += "    " + room + ": " + strconv.Itoa(standing.CodesByRoom[room]) + "\n"
+		}
+	}
+	return top
+}
+
+func listTeamTop(a *App, req *Request) error {
+	standings, err := teamStandings(a)
+	if err != nil {
+		return err
+	}
+	if len(standings) == 0 {
+		msg := tgbotapi.NewMessage(req.ChatID, "No teams were added yet")
+		a.bot.Send(msg)
+		return nil
+	}
+
+	msg := tgbotapi.NewMessage(req.ChatID, formatTeamStandings(standings))
+	a.bot.Send(msg)
+	return nil
+}
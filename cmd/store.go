@@ -0,0 +1,172 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+type UserProfile struct {
+	FromID   int64
+	Username string
+	Team     string
+	Admin    bool
+	// StandingsSent is the start of the last game whose final standings
+	// the user was sent
+	StandingsSent int64
+}
+
+// usernameKey is the form in which usernames are unique, so that "Alice"
+// and "alice" cannot both be registered
+func usernameKey(username string) string {
+	return strings.ToLower(username)
+}
+
+// Team is a team players can join. Users refer to it by Name.
+type Team struct {
+	Name        string
+	DisplayName string
+	Emoji       string
+}
+
+type DozorCode struct {
+	Code string
+	Room string
+	Note string
+	// Points are what the code is worth, negative for penalty codes
+	Points int
+	// Bonus is the first find bonus earned by the finder
+	Bonus    int
+	FromID   int64
+	Username string
+	// FoundAt is the unix time of the claim, 0 for codes found before it
+	// was recorded
+	FoundAt int64
+}
+
+// PuzzleSet is a named puzzle whose answers players find with /answer
+type PuzzleSet struct {
+	Name string
+	// Matching is how strictly answers are compared, see matchAnswer
+	Matching string
+	// Tolerance is the number of typos a fuzzy set forgives
+	Tolerance int
+}
+
+type PuzzleAnswer struct {
+	Set    string
+	Answer string
+	// Aliases are other spellings accepted for the answer
+	Aliases []string
+	FromID  int64
+	FoundAt int64
+}
+
+// Game is the window in which players may submit codes and answers. All
+// times are unix times; EndsAt is 0 until the game is scheduled to end.
+type Game struct {
+	StartsAt int64
+	EndsAt   int64
+	Paused   bool
+	// AnnouncedAt is when the final standings were sent, 0 before
+	AnnouncedAt int64
+}
+
+// Conversation is a dialog in progress: the command being filled in, the
+// step waiting for input and the values collected so far.
+type Conversation struct {
+	FromID    int64
+	Command   string
+	State     string
+	Fields    map[string]string
+	Timestamp int64
+}
+
+// Store hides the persistence of users, teams, codes, puzzles, the game,
+// conversations and processed updates. Getters return nil without an error when the item does not exist.
+type Store interface {
+	GetUser(fromID int64) (*UserProfile, error)
+	// GetUsers loads the users with the given ids at once, leaving out the
+	// ones that do not exist
+	GetUsers(fromIDs []int64) (map[int64]*UserProfile, error)
+	// SetUsername reserves the username for fromID, releasing the previous
+	// one, and reports false when another user holds it. Usernames are
+	// compared by usernameKey.
+	SetUsername(fromID int64, username string) (bool, error)
+	// FindUsername returns the user who reserved the username, regardless
+	// of case, or 0 when it is free
+	FindUsername(username string) (int64, error)
+	SetTeam(fromID int64, team string) error
+	SetAdmin(fromID int64, enabled bool) error
+	ListUsers() ([]*UserProfile, error)
+
+	GetTeam(name string) (*Team, error)
+	ListTeams() ([]*Team, error)
+	PutTeam(team *Team) error
+	DeleteTeam(name string) error
+
+	GetCode(code string) (*DozorCode, error)
+	ListCodes() ([]*DozorCode, error)
+	PutCode(code *DozorCode) error
+	// ClaimCode makes fromID the finder of the code at foundAt unless it was
+	// found before. It returns the code as stored after the attempt, nil if
+	// it does not exist, and whether this call claimed it.
+	ClaimCode(code string, fromID int64, foundAt int64) (*DozorCode, bool, error)
+	// OpenRoom records the code as the first found one of its room and gives
+	// it the bonus, unless the room was opened before. It reports whether
+	// this call opened the room.
+	OpenRoom(room string, code string, bonus int) (bool, error)
+	// DeleteCode removes the code, and the opening of its room when the code
+	// opened it
+	DeleteCode(code string) error
+
+	GetPuzzleSet(name string) (*PuzzleSet, error)
+	ListPuzzleSets() ([]*PuzzleSet, error)
+	PutPuzzleSet(set *PuzzleSet) error
+
+	FindAnswer(set string, answer string) (*PuzzleAnswer, error)
+	ListAnswers(set string) ([]*PuzzleAnswer, error)
+	PutAnswer(set string, answer string) error
+	AddAlias(set string, answer string, alias string) error
+	// ClaimAnswer makes fromID the finder of the answer like ClaimCode
+	ClaimAnswer(set string, answer string, fromID int64, foundAt int64) (*PuzzleAnswer, bool, error)
+
+	// GetGame returns the current game, nil before the first /startgame
+	GetGame() (*Game, error)
+	PutGame(game *Game) error
+	// AnnounceGame records that the final standings of the game were sent
+	// and reports false when they already were
+	AnnounceGame(announcedAt int64) (bool, error)
+	// Migrated reports whether the one-time migration with the name ran
+	Migrated(name string) (bool, error)
+	// MarkMigrated records that the one-time migration with the name ran
+	MarkMigrated(name string) error
+
+	// MarkStandingsSent records that the user is sent the final standings
+	// of the game that started at gameStart and reports false when they
+	// already were
+	MarkStandingsSent(fromID int64, gameStart int64) (bool, error)
+
+	GetConversation(fromID int64) (*Conversation, error)
+	PutConversation(conversation *Conversation) error
+	DeleteConversation(fromID int64) error
+
+	// LeaseUpdate records in the processed-update ledger that the update is
+	// being processed until startedAt+lease, unless it is processed or
+	// leased already. Expired entries count as missing.
+	LeaseUpdate(updateID int, startedAt time.Time, lease time.Duration) (UpdateStatus, error)
+	// MarkUpdateProcessed keeps the update in the ledger as processed for ttl
+	MarkUpdateProcessed(updateID int, processedAt time.Time, ttl time.Duration) error
+	UnmarkUpdateProcessed(updateID int) error
+}
+
+// UpdateStatus is what LeaseUpdate found in the processed-update ledger
+type UpdateStatus int
+
+const (
+	// UpdateLeased means the caller got the lease and processes the update
+	UpdateLeased UpdateStatus = iota
+	// UpdateInProgress means another attempt holds the lease
+	UpdateInProgress
+	// UpdateProcessed means the update was processed before
+	UpdateProcessed
+)
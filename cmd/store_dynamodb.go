@@ -0,0 +1,1196 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+type dynamoStore struct {
+	svc    *dynamodb.DynamoDB
+	tables TableConfig
+}
+
+func newDynamoStore(svc *dynamodb.DynamoDB, tables TableConfig) *dynamoStore {
+	return &dynamoStore{svc: svc, tables: tables}
+}
+
+// scan reads all pages of the table and is used for every Scan of the store.
+// A single Scan stops after 1 MB and returns LastEvaluatedKey to continue from,
+// so reading only the first page silently truncates the results.
+func (s *dynamoStore) scan(input *dynamodb.ScanInput) ([]map[string]*dynamodb.AttributeValue, error) {
+	items := []map[string]*dynamodb.AttributeValue{}
+	for {
+		result, err := s.svc.Scan(input)
+		if err != nil {
+			log.Printf("failed to scan table: %v\n", err)
+			return nil, err
+		}
+		items = append(items, result.Items...)
+
+		if len(result.LastEvaluatedKey) == 0 {
+			return items, nil
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+}
+
+// query reads all pages of the query results, like scan does for scans
+func (s *dynamoStore) query(input *dynamodb.QueryInput) ([]map[string]*dynamodb.AttributeValue, error) {
+	items := []map[string]*dynamodb.AttributeValue{}
+	for {
+		result, err := s.svc.Query(input)
+		if err != nil {
+			log.Printf("failed to query table: %v\n", err)
+			return nil, err
+		}
+		items = append(items, result.Items...)
+
+		if len(result.LastEvaluatedKey) == 0 {
+			return items, nil
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+}
+
+func fromIDKey(fromID int64) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"from_id": {
+			N: aws.String(fmt.Sprint(fromID)),
+		},
+	}
+}
+
+func parseFromID(item map[string]*dynamodb.AttributeValue) int64 {
+	return parseNumber(item, "from_id")
+}
+
+// parseNumber returns the integer attribute of the item or 0 if it is missing
+func parseNumber(item map[string]*dynamodb.AttributeValue, name string) int64 {
+	if item[name] == nil || item[name].N == nil {
+		return 0
+	}
+	number, err := strconv.ParseInt(*item[name].N, 10, 64)
+	if err != nil {
+		log.Printf("failed to parse %s: %v\n", name, err)
+		return 0
+	}
+	return number
+}
+
+func userFromItem(item map[string]*dynamodb.AttributeValue) *UserProfile {
+	user := &UserProfile{
+		FromID: parseFromID(item),
+	}
+	if item["username"] != nil {
+		user.Username = *item["username"].S
+	}
+	if item["team"] != nil {
+		user.Team = *item["team"].S
+	}
+	if item["admin"] != nil {
+		user.Admin = *item["admin"].BOOL
+	}
+	user.StandingsSent = parseNumber(item, "standings_sent")
+	return user
+}
+
+func teamFromItem(item map[string]*dynamodb.AttributeValue) *Team {
+	team := &Team{
+		Name: *item["name"].S,
+	}
+	if item["display_name"] != nil {
+		team.DisplayName = *item["display_name"].S
+	}
+	if item["emoji"] != nil {
+		team.Emoji = *item["emoji"].S
+	}
+	return team
+}
+
+func codeFromItem(item map[string]*dynamodb.AttributeValue) *DozorCode {
+	dozorCode := &DozorCode{
+		Code:    *item["code"].S,
+		Points:  defaultCodePoints,
+		FromID:  parseFromID(item),
+		FoundAt: parseNumber(item, "found_at"),
+	}
+	// codes added before points existed are worth the default
+	if item["points"] != nil {
+		dozorCode.Points = int(parseNumber(item, "points"))
+	}
+	dozorCode.Bonus = int(parseNumber(item, "bonus"))
+	if item["room"] != nil {
+		dozorCode.Room = *item["room"].S
+	}
+	if item["note"] != nil {
+		dozorCode.Note = *item["note"].S
+	}
+	return dozorCode
+}
+
+func answerFromItem(item map[string]*dynamodb.AttributeValue) *PuzzleAnswer {
+	answer := &PuzzleAnswer{
+		FromID:  parseFromID(item),
+		FoundAt: parseNumber(item, "found_at"),
+	}
+	if item["puzzle_set"] != nil {
+		answer.Set = *item["puzzle_set"].S
+	}
+	if item["answer"] != nil {
+		answer.Answer = *item["answer"].S
+	}
+	if item["aliases"] != nil {
+		answer.Aliases = aws.StringValueSlice(item["aliases"].SS)
+	}
+	return answer
+}
+
+func puzzleSetFromItem(item map[string]*dynamodb.AttributeValue) *PuzzleSet {
+	set := &PuzzleSet{
+		Name:      *item["name"].S,
+		Tolerance: int(parseNumber(item, "tolerance")),
+	}
+	if item["matching"] != nil {
+		set.Matching = *item["matching"].S
+	}
+	return set
+}
+
+func (s *dynamoStore) GetUser(fromID int64) (*UserProfile, error) {
+	result, err := s.svc.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(s.tables.UserProfile),
+		Key:       fromIDKey(fromID),
+	})
+	if err != nil {
+		log.Printf("failed to get item: %v\n", err)
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+	return userFromItem(result.Item), nil
+}
+
+// batchGetLimit is the number of keys a single BatchGetItem accepts
+const batchGetLimit = 100
+
+// unprocessed keys of a batch are requested again at most
+// batchGetAttempts times, waiting up to maxBatchGetBackoff in between
+const (
+	batchGetAttempts   = 5
+	maxBatchGetBackoff = time.Second
+)
+
+func (s *dynamoStore) GetUsers(fromIDs []int64) (map[int64]*UserProfile, error) {
+	keys := []map[string]*dynamodb.AttributeValue{}
+	seen := make(map[int64]bool)
+	for _, fromID := range fromIDs {
+		if !seen[fromID] {
+			seen[fromID] = true
+			keys = append(keys, fromIDKey(fromID))
+		}
+	}
+
+	users := make(map[int64]*UserProfile, len(keys))
+	for len(keys) > 0 {
+		n := len(keys)
+		if n > batchGetLimit {
+			n = batchGetLimit
+		}
+		requestItems := map[string]*dynamodb.KeysAndAttributes{
+			s.tables.UserProfile: {
+				Keys: keys[:n],
+			},
+		}
+		keys = keys[n:]
+
+		backoff := 50 * time.Millisecond
+		for attempt := 1; len(requestItems) > 0; attempt++ {
+			if attempt > batchGetAttempts {
+				// a throttling error, so the caller retries the update later
+				return nil, awserr.New(dynamodb.ErrCodeProvisionedThroughputExceededException,
+					"user profiles left unprocessed after "+strconv.Itoa(batchGetAttempts)+" attempts", nil)
+			}
+			result, err := s.svc.BatchGetItem(&dynamodb.BatchGetItemInput{
+				RequestItems: requestItems,
+			})
+			if err != nil {
+				log.Printf("failed to batch get items: %v\n", err)
+				return nil, err
+			}
+			for _, item := range result.Responses[s.tables.UserProfile] {
+				user := userFromItem(item)
+				users[user.FromID] = user
+			}
+
+			// keys left out because of throttling or the response size
+			// limit are requested again
+			requestItems = result.UnprocessedKeys
+			if len(requestItems) > 0 && attempt < batchGetAttempts {
+				time.Sleep(backoff)
+				if backoff *= 2; backoff > maxBatchGetBackoff {
+					backoff = maxBatchGetBackoff
+				}
+			}
+		}
+	}
+	return users, nil
+}
+
+func (s *dynamoStore) updateUser(fromID int64, expression string, value *dynamodb.AttributeValue) error {
+	// UpdateItem creates the item if it does not exist yet
+	_, err := s.svc.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:        aws.String(s.tables.UserProfile),
+		Key:              fromIDKey(fromID),
+		UpdateExpression: aws.String(expression),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":v": value,
+		},
+	})
+	if err != nil {
+		log.Printf("failed to update item: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+func usernameReservationKey(username string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"username_key": {
+			S: aws.String(usernameKey(username)),
+		},
+	}
+}
+
+func (s *dynamoStore) FindUsername(username string) (int64, error) {
+	result, err := s.svc.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(s.tables.Username),
+		Key:       usernameReservationKey(username),
+	})
+	if err != nil {
+		log.Printf("failed to get item: %v\n", err)
+		return 0, err
+	}
+	if result.Item == nil {
+		return 0, nil
+	}
+	return parseNumber(result.Item, "from_id"), nil
+}
+
+// backfillUsernames reserves the usernames of profiles registered before
+// the Username table existed. It runs once; when legacy profiles share a
+// username regardless of case, the first one scanned keeps it.
+func (s *dynamoStore) backfillUsernames() error {
+	migrated, err := s.Migrated("usernames")
+	if err != nil || migrated {
+		return err
+	}
+
+	users, err := s.ListUsers()
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		if user.Username == "" {
+			continue
+		}
+		reservation := usernameReservationKey(user.Username)
+		reservation["from_id"] = &dynamodb.AttributeValue{
+			N: aws.String(fmt.Sprint(user.FromID)),
+		}
+		_, err := s.svc.PutItem(&dynamodb.PutItemInput{
+			TableName:           aws.String(s.tables.Username),
+			Item:                reservation,
+			ConditionExpression: aws.String("attribute_not_exists(username_key) OR from_id = :f"),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":f": reservation["from_id"],
+			},
+		})
+		if err != nil {
+			if isConditionalCheckFailed(err) {
+				log.Printf("username %s of %d is reserved by another user\n", user.Username, user.FromID)
+				continue
+			}
+			log.Printf("failed to put item: %v\n", err)
+			return err
+		}
+	}
+	return s.MarkMigrated("usernames")
+}
+
+func (s *dynamoStore) SetUsername(fromID int64, username string) (bool, error) {
+	user, err := s.GetUser(fromID)
+	if err != nil {
+		return false, err
+	}
+
+	// the reservation item in the Username table makes the name unique, it
+	// is written together with the profile or not at all
+	reservation := usernameReservationKey(username)
+	reservation["from_id"] = &dynamodb.AttributeValue{
+		N: aws.String(fmt.Sprint(fromID)),
+	}
+	items := []*dynamodb.TransactWriteItem{
+		{
+			Put: &dynamodb.Put{
+				TableName:           aws.String(s.tables.Username),
+				Item:                reservation,
+				ConditionExpression: aws.String("attribute_not_exists(username_key) OR from_id = :f"),
+				ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+					":f": {
+						N: aws.String(fmt.Sprint(fromID)),
+					},
+				},
+			},
+		},
+		{
+			Update: &dynamodb.Update{
+				TableName:        aws.String(s.tables.UserProfile),
+				Key:              fromIDKey(fromID),
+				UpdateExpression: aws.String("set username = :v"),
+				ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+					":v": {
+						S: aws.String(username),
+					},
+				},
+			},
+		},
+	}
+	if user != nil && user.Username != "" && usernameKey(user.Username) != usernameKey(username) {
+		// release the previous username
+		items = append(items, &dynamodb.TransactWriteItem{
+			Delete: &dynamodb.Delete{
+				TableName: aws.String(s.tables.Username),
+				Key:       usernameReservationKey(user.Username),
+			},
+		})
+	}
+
+	_, err = s.svc.TransactWriteItems(&dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	})
+	if err != nil {
+		if isTransactionConditionFailed(err) {
+			return false, nil
+		}
+		log.Printf("failed to write items: %v\n", err)
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *dynamoStore) SetTeam(fromID int64, team string) error {
+	return s.updateUser(fromID, "set team = :v", &dynamodb.AttributeValue{S: aws.String(team)})
+}
+
+func (s *dynamoStore) SetAdmin(fromID int64, enabled bool) error {
+	return s.updateUser(fromID, "set admin = :v", &dynamodb.AttributeValue{BOOL: aws.Bool(enabled)})
+}
+
+func (s *dynamoStore) ListUsers() ([]*UserProfile, error) {
+	items, err := s.scan(&dynamodb.ScanInput{
+		TableName: aws.String(s.tables.UserProfile),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]*UserProfile, 0, len(items))
+	for _, item := range items {
+		users = append(users, userFromItem(item))
+	}
+	return users, nil
+}
+
+func teamKey(name string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"name": {
+			S: aws.String(name),
+		},
+	}
+}
+
+func (s *dynamoStore) GetTeam(name string) (*Team, error) {
+	result, err := s.svc.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(s.tables.Team),
+		Key:       teamKey(name),
+	})
+	if err != nil {
+		log.Printf("failed to get item: %v\n", err)
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+	return teamFromItem(result.Item), nil
+}
+
+func (s *dynamoStore) ListTeams() ([]*Team, error) {
+	items, err := s.scan(&dynamodb.ScanInput{
+		TableName: aws.String(s.tables.Team),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	teams := make([]*Team, 0, len(items))
+	for _, item := range items {
+		teams = append(teams, teamFromItem(item))
+	}
+	return teams, nil
+}
+
+func (s *dynamoStore) PutTeam(team *Team) error {
+	_, err := s.svc.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(s.tables.Team),
+		Item: map[string]*dynamodb.AttributeValue{
+			"name": {
+				S: aws.String(team.Name),
+			},
+			"display_name": {
+				S: aws.String(team.DisplayName),
+			},
+			"emoji": {
+				S: aws.String(team.Emoji),
+			},
+		},
+	})
+	if err != nil {
+		log.Printf("failed to put item: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+func (s *dynamoStore) DeleteTeam(name string) error {
+	_, err := s.svc.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(s.tables.Team),
+		Key:       teamKey(name),
+	})
+	if err != nil {
+		log.Printf("failed to delete item: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+func codeKey(code string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"code": {
+			S: aws.String(code),
+		},
+	}
+}
+
+func (s *dynamoStore) GetCode(code string) (*DozorCode, error) {
+	result, err := s.svc.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(s.tables.DozorCode),
+		Key:       codeKey(code),
+	})
+	if err != nil {
+		log.Printf("failed to get item: %v\n", err)
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+	return codeFromItem(result.Item), nil
+}
+
+func (s *dynamoStore) ListCodes() ([]*DozorCode, error) {
+	items, err := s.scan(&dynamodb.ScanInput{
+		TableName: aws.String(s.tables.DozorCode),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	codes := make([]*DozorCode, 0, len(items))
+	for _, item := range items {
+		codes = append(codes, codeFromItem(item))
+	}
+	return codes, nil
+}
+
+func (s *dynamoStore) PutCode(code *DozorCode) error {
+	_, err := s.svc.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(s.tables.DozorCode),
+		Item: map[string]*dynamodb.AttributeValue{
+			"code": {
+				S: aws.String(code.Code),
+			},
+			"room": {
+				S: aws.String(code.Room),
+			},
+			"note": {
+				S: aws.String(code.Note),
+			},
+			"points": {
+				N: aws.String(strconv.Itoa(code.Points)),
+			},
+		},
+	})
+	if err != nil {
+		log.Printf("failed to put item: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+func (s *dynamoStore) ClaimCode(code string, fromID int64, foundAt int64) (*DozorCode, bool, error) {
+	// the condition keeps the first finder when several users race
+	result, err := s.svc.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:           aws.String(s.tables.DozorCode),
+		Key:                 codeKey(code),
+		UpdateExpression:    aws.String("set from_id = :f, found_at = :t"),
+		ConditionExpression: aws.String("attribute_exists(code) AND attribute_not_exists(from_id)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":f": {
+				N: aws.String(fmt.Sprint(fromID)),
+			},
+			":t": {
+				N: aws.String(fmt.Sprint(foundAt)),
+			},
+		},
+		ReturnValues: aws.String(dynamodb.ReturnValueAllNew),
+	})
+	if err != nil {
+		if isConditionalCheckFailed(err) {
+			// either the code does not exist or it is already claimed
+			dozorCode, err := s.GetCode(code)
+			return dozorCode, false, err
+		}
+		log.Printf("failed to update item: %v\n", err)
+		return nil, false, err
+	}
+	return codeFromItem(result.Attributes), true, nil
+}
+
+func (s *dynamoStore) OpenRoom(room string, code string, bonus int) (bool, error) {
+	// the opener item in the Room table and the bonus of the code are
+	// written together or not at all
+	_, err := s.svc.TransactWriteItems(&dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			{
+				Put: &dynamodb.Put{
+					TableName: aws.String(s.tables.Room),
+					Item: map[string]*dynamodb.AttributeValue{
+						"room": {
+							S: aws.String(room),
+						},
+						"code": {
+							S: aws.String(code),
+						},
+					},
+					ConditionExpression: aws.String("attribute_not_exists(room)"),
+				},
+			},
+			{
+				Update: &dynamodb.Update{
+					TableName:           aws.String(s.tables.DozorCode),
+					Key:                 codeKey(code),
+					UpdateExpression:    aws.String("set bonus = :b"),
+					ConditionExpression: aws.String("attribute_exists(code)"),
+					ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+						":b": {
+							N: aws.String(strconv.Itoa(bonus)),
+						},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		if isTransactionConditionFailed(err) {
+			return false, nil
+		}
+		log.Printf("failed to write items: %v\n", err)
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *dynamoStore) DeleteCode(code string) error {
+	dozorCode, err := s.GetCode(code)
+	if err != nil {
+		return err
+	}
+	if dozorCode != nil {
+		// the room can be opened again when its opener is removed. The room
+		// goes first, so a failure leaves the code for the retry.
+		_, err := s.svc.DeleteItem(&dynamodb.DeleteItemInput{
+			TableName: aws.String(s.tables.Room),
+			Key: map[string]*dynamodb.AttributeValue{
+				"room": {
+					S: aws.String(dozorCode.Room),
+				},
+			},
+			ConditionExpression: aws.String("code = :c"),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":c": {
+					S: aws.String(code),
+				},
+			},
+		})
+		if err != nil && !isConditionalCheckFailed(err) {
+			log.Printf("failed to delete item: %v\n", err)
+			return err
+		}
+	}
+
+	_, err = s.svc.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(s.tables.DozorCode),
+		Key:       codeKey(code),
+	})
+	if err != nil {
+		log.Printf("failed to delete item: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+func puzzleSetKey(name string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"name": {
+			S: aws.String(name),
+		},
+	}
+}
+
+func (s *dynamoStore) GetPuzzleSet(name string) (*PuzzleSet, error) {
+	result, err := s.svc.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(s.tables.PuzzleSet),
+		Key:       puzzleSetKey(name),
+	})
+	if err != nil {
+		log.Printf("failed to get item: %v\n", err)
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+	return puzzleSetFromItem(result.Item), nil
+}
+
+func (s *dynamoStore) ListPuzzleSets() ([]*PuzzleSet, error) {
+	items, err := s.scan(&dynamodb.ScanInput{
+		TableName: aws.String(s.tables.PuzzleSet),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sets := make([]*PuzzleSet, 0, len(items))
+	for _, item := range items {
+		sets = append(sets, puzzleSetFromItem(item))
+	}
+	return sets, nil
+}
+
+func (s *dynamoStore) PutPuzzleSet(set *PuzzleSet) error {
+	_, err := s.svc.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(s.tables.PuzzleSet),
+		Item: map[string]*dynamodb.AttributeValue{
+			"name": {
+				S: aws.String(set.Name),
+			},
+			"matching": {
+				S: aws.String(set.Matching),
+			},
+			"tolerance": {
+				N: aws.String(strconv.Itoa(set.Tolerance)),
+			},
+		},
+	})
+	if err != nil {
+		log.Printf("failed to put item: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+// answerKey is the key of the PuzzleAnswer table: the set is the partition
+// key and the answer is the sort key
+func answerKey(set string, answer string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"puzzle_set": {
+			S: aws.String(set),
+		},
+		"answer": {
+			S: aws.String(answer),
+		},
+	}
+}
+
+func (s *dynamoStore) FindAnswer(set string, answer string) (*PuzzleAnswer, error) {
+	result, err := s.svc.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(s.tables.PuzzleAnswer),
+		Key:       answerKey(set, answer),
+	})
+	if err != nil {
+		log.Printf("failed to get item: %v\n", err)
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+	return answerFromItem(result.Item), nil
+}
+
+func (s *dynamoStore) ListAnswers(set string) ([]*PuzzleAnswer, error) {
+	items, err := s.query(&dynamodb.QueryInput{
+		TableName:              aws.String(s.tables.PuzzleAnswer),
+		KeyConditionExpression: aws.String("puzzle_set = :s"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":s": {
+				S: aws.String(set),
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	answers := make([]*PuzzleAnswer, 0, len(items))
+	for _, item := range items {
+		answers = append(answers, answerFromItem(item))
+	}
+	return answers, nil
+}
+
+func (s *dynamoStore) PutAnswer(set string, answer string) error {
+	_, err := s.svc.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(s.tables.PuzzleAnswer),
+		Item:      answerKey(set, answer),
+	})
+	if err != nil {
+		log.Printf("failed to put item: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+func (s *dynamoStore) AddAlias(set string, answer string, alias string) error {
+	_, err := s.svc.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:           aws.String(s.tables.PuzzleAnswer),
+		Key:                 answerKey(set, answer),
+		UpdateExpression:    aws.String("add aliases :a"),
+		ConditionExpression: aws.String("attribute_exists(answer)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":a": {
+				SS: aws.StringSlice([]string{alias}),
+			},
+		},
+	})
+	if err != nil {
+		log.Printf("failed to update item: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+// migrateLegacyPuzzles copies the answers of the PairA and PairB tables of
+// earlier versions, finders included, into the puzzles a3 and b1. A puzzle
+// is created after its answers, so a failed copy is repeated on the next
+// start and a puzzle that exists already is left alone.
+func (s *dynamoStore) migrateLegacyPuzzles() error {
+	legacyTables := map[string]string{
+		"a3": s.tables.PairA,
+		"b1": s.tables.PairB,
+	}
+	for set, table := range legacyTables {
+		existing, err := s.GetPuzzleSet(set)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			continue
+		}
+
+		items, err := s.scan(&dynamodb.ScanInput{
+			TableName: aws.String(table),
+		})
+		if err != nil {
+			var aerr awserr.Error
+			if errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeResourceNotFoundException {
+				// nothing to migrate
+				continue
+			}
+			return err
+		}
+
+		for _, item := range items {
+			if item["answer"] == nil || item["answer"].S == nil {
+				continue
+			}
+			answer := answerKey(set, *item["answer"].S)
+			if item["from_id"] != nil {
+				answer["from_id"] = item["from_id"]
+			}
+			_, err := s.svc.PutItem(&dynamodb.PutItemInput{
+				TableName: aws.String(s.tables.PuzzleAnswer),
+				Item:      answer,
+			})
+			if err != nil {
+				log.Printf("failed to put item: %v\n", err)
+				return err
+			}
+		}
+		if err := s.PutPuzzleSet(&PuzzleSet{Name: set, Matching: matchingNormal}); err != nil {
+			return err
+		}
+		log.Printf("migrated %d answers of %s to puzzle %s\n", len(items), table, set)
+	}
+	return nil
+}
+
+func (s *dynamoStore) ClaimAnswer(set string, answer string, fromID int64, foundAt int64) (*PuzzleAnswer, bool, error) {
+	// the condition keeps the first finder when several users race
+	result, err := s.svc.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:           aws.String(s.tables.PuzzleAnswer),
+		Key:                 answerKey(set, answer),
+		UpdateExpression:    aws.String("set from_id = :f, found_at = :t"),
+		ConditionExpression: aws.String("attribute_exists(answer) AND attribute_not_exists(from_id)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":f": {
+				N: aws.String(fmt.Sprint(fromID)),
+			},
+			":t": {
+				N: aws.String(fmt.Sprint(foundAt)),
+			},
+		},
+		ReturnValues: aws.String(dynamodb.ReturnValueAllNew),
+	})
+	if err != nil {
+		if isConditionalCheckFailed(err) {
+			// either the answer does not exist or it is already found
+			puzzleAnswer, err := s.FindAnswer(set, answer)
+			return puzzleAnswer, false, err
+		}
+		log.Printf("failed to update item: %v\n", err)
+		return nil, false, err
+	}
+	return answerFromItem(result.Attributes), true, nil
+}
+
+// gameKey is the key of the only item of the Game table
+func gameKey() map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"name": {
+			S: aws.String("current"),
+		},
+	}
+}
+
+func (s *dynamoStore) GetGame() (*Game, error) {
+	result, err := s.svc.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(s.tables.Game),
+		Key:       gameKey(),
+	})
+	if err != nil {
+		log.Printf("failed to get item: %v\n", err)
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+
+	game := &Game{
+		StartsAt:    parseNumber(result.Item, "starts_at"),
+		EndsAt:      parseNumber(result.Item, "ends_at"),
+		AnnouncedAt: parseNumber(result.Item, "announced_at"),
+	}
+	if result.Item["paused"] != nil && result.Item["paused"].BOOL != nil {
+		game.Paused = *result.Item["paused"].BOOL
+	}
+	return game, nil
+}
+
+func (s *dynamoStore) PutGame(game *Game) error {
+	item := gameKey()
+	item["starts_at"] = &dynamodb.AttributeValue{N: aws.String(fmt.Sprint(game.StartsAt))}
+	item["ends_at"] = &dynamodb.AttributeValue{N: aws.String(fmt.Sprint(game.EndsAt))}
+	item["paused"] = &dynamodb.AttributeValue{BOOL: aws.Bool(game.Paused)}
+	item["announced_at"] = &dynamodb.AttributeValue{N: aws.String(fmt.Sprint(game.AnnouncedAt))}
+
+	_, err := s.svc.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(s.tables.Game),
+		Item:      item,
+	})
+	if err != nil {
+		log.Printf("failed to put item: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+func (s *dynamoStore) AnnounceGame(announcedAt int64) (bool, error) {
+	// the condition lets only one of several racing updates announce
+	_, err := s.svc.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:           aws.String(s.tables.Game),
+		Key:                 gameKey(),
+		UpdateExpression:    aws.String("set announced_at = :t"),
+		ConditionExpression: aws.String("attribute_exists(starts_at) AND announced_at = :z"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":t": {
+				N: aws.String(fmt.Sprint(announcedAt)),
+			},
+			":z": {
+				N: aws.String("0"),
+			},
+		},
+	})
+	if err != nil {
+		if isConditionalCheckFailed(err) {
+			return false, nil
+		}
+		log.Printf("failed to update item: %v\n", err)
+		return false, err
+	}
+	return true, nil
+}
+
+// migrationKey is the key of the marker of a one-time migration. The markers
+// are kept in the Game table next to the current game.
+func migrationKey(name string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"name": {
+			S: aws.String("migration:" + name),
+		},
+	}
+}
+
+func (s *dynamoStore) Migrated(name string) (bool, error) {
+	result, err := s.svc.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(s.tables.Game),
+		Key:       migrationKey(name),
+	})
+	if err != nil {
+		log.Printf("failed to get item: %v\n", err)
+		return false, err
+	}
+	return result.Item != nil, nil
+}
+
+func (s *dynamoStore) MarkMigrated(name string) error {
+	_, err := s.svc.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(s.tables.Game),
+		Item:      migrationKey(name),
+	})
+	if err != nil {
+		log.Printf("failed to put item: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+func (s *dynamoStore) MarkStandingsSent(fromID int64, gameStart int64) (bool, error) {
+	// the condition lets only one of several announcers send to the user
+	_, err := s.svc.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:           aws.String(s.tables.UserProfile),
+		Key:                 fromIDKey(fromID),
+		UpdateExpression:    aws.String("set standings_sent = :g"),
+		ConditionExpression: aws.String("attribute_exists(from_id) AND (attribute_not_exists(standings_sent) OR standings_sent <> :g)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":g": {
+				N: aws.String(fmt.Sprint(gameStart)),
+			},
+		},
+	})
+	if err != nil {
+		if isConditionalCheckFailed(err) {
+			return false, nil
+		}
+		log.Printf("failed to update item: %v\n", err)
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *dynamoStore) GetConversation(fromID int64) (*Conversation, error) {
+	result, err := s.svc.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(s.tables.WaitingCommand),
+		Key:       fromIDKey(fromID),
+	})
+	if err != nil {
+		log.Printf("failed to get item: %v\n", err)
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+
+	conversation := &Conversation{
+		FromID: fromID,
+		Fields: make(map[string]string),
+	}
+	if result.Item["command"] != nil {
+		conversation.Command = *result.Item["command"].S
+	}
+	if result.Item["state"] != nil {
+		conversation.State = *result.Item["state"].S
+	}
+	if result.Item["fields"] != nil {
+		for name, value := range result.Item["fields"].M {
+			if value.S != nil {
+				conversation.Fields[name] = *value.S
+			}
+		}
+	}
+	if result.Item["timestamp"] != nil {
+		// convert timestampStr to int64
+		t, err := strconv.ParseInt(*result.Item["timestamp"].N, 10, 64)
+		if err != nil {
+			log.Printf("failed to parse timestamp: %v\n", err)
+		} else {
+			conversation.Timestamp = t
+		}
+	}
+	return conversation, nil
+}
+
+func (s *dynamoStore) PutConversation(conversation *Conversation) error {
+	fields := make(map[string]*dynamodb.AttributeValue)
+	for name, value := range conversation.Fields {
+		fields[name] = &dynamodb.AttributeValue{
+			S: aws.String(value),
+		}
+	}
+
+	_, err := s.svc.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(s.tables.WaitingCommand),
+		Item: map[string]*dynamodb.AttributeValue{
+			"from_id": {
+				N: aws.String(fmt.Sprint(conversation.FromID)),
+			},
+			"command": {
+				S: aws.String(conversation.Command),
+			},
+			"state": {
+				S: aws.String(conversation.State),
+			},
+			"fields": {
+				M: fields,
+			},
+			"timestamp": {
+				N: aws.String(fmt.Sprint(conversation.Timestamp)),
+			},
+		},
+	})
+	if err != nil {
+		log.Printf("failed to put item: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+func (s *dynamoStore) DeleteConversation(fromID int64) error {
+	_, err := s.svc.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(s.tables.WaitingCommand),
+		Key:       fromIDKey(fromID),
+	})
+	if err != nil {
+		log.Printf("failed to delete item: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+func updateIDKey(updateID int) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"update_id": {
+			N: aws.String(fmt.Sprint(updateID)),
+		},
+	}
+}
+
+func isConditionalCheckFailed(err error) bool {
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
+
+// isTransactionConditionFailed reports whether a transaction was cancelled
+// because a condition of one of its items failed
+func isTransactionConditionFailed(err error) bool {
+	var canceled *dynamodb.TransactionCanceledException
+	if !errors.As(err, &canceled) {
+		return false
+	}
+	for _, reason := range canceled.CancellationReasons {
+		if reason.Code != nil && *reason.Code == "ConditionalCheckFailed" {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *dynamoStore) LeaseUpdate(updateID int, startedAt time.Time, lease time.Duration) (UpdateStatus, error) {
+	// expires_at is the TTL attribute of the table. TTL deletion may lag
+	// behind, so expired items are overwritten as if they were missing.
+	item := updateIDKey(updateID)
+	item["expires_at"] = &dynamodb.AttributeValue{
+		N: aws.String(fmt.Sprint(startedAt.Add(lease).Unix())),
+	}
+	item["leased"] = &dynamodb.AttributeValue{
+		BOOL: aws.Bool(true),
+	}
+	_, err := s.svc.PutItem(&dynamodb.PutItemInput{
+		TableName:           aws.String(s.tables.ProcessedUpdate),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(update_id) OR expires_at < :now"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":now": {
+				N: aws.String(fmt.Sprint(startedAt.Unix())),
+			},
+		},
+		ReturnValuesOnConditionCheckFailure: aws.String(dynamodb.ReturnValuesOnConditionCheckFailureAllOld),
+	})
+	if err != nil {
+		var failed *dynamodb.ConditionalCheckFailedException
+		if errors.As(err, &failed) {
+			// entries written before leases existed are processed updates
+			if leased := failed.Item["leased"]; leased != nil && leased.BOOL != nil && *leased.BOOL {
+				return UpdateInProgress, nil
+			}
+			return UpdateProcessed, nil
+		}
+		log.Printf("failed to put item: %v\n", err)
+		return UpdateLeased, err
+	}
+	return UpdateLeased, nil
+}
+
+func (s *dynamoStore) MarkUpdateProcessed(updateID int, processedAt time.Time, ttl time.Duration) error {
+	item := updateIDKey(updateID)
+	item["expires_at"] = &dynamodb.AttributeValue{
+		N: aws.String(fmt.Sprint(processedAt.Add(ttl).Unix())),
+	}
+	_, err := s.svc.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(s.tables.ProcessedUpdate),
+		Item:      item,
+	})
+	if err != nil {
+		log.Printf("failed to put item: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+func (s *dynamoStore) UnmarkUpdateProcessed(updateID int) error {
+	_, err := s.svc.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(s.tables.ProcessedUpdate),
+		Key:       updateIDKey(updateID),
+	})
+	if err != nil {
+		log.Printf("failed to delete item: %v\n", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,476 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// memoryStore keeps everything in process memory. It is meant for running
+// the bot locally and in tests; nothing survives a restart.
+type memoryStore struct {
+	mu            sync.Mutex
+	users         map[int64]UserProfile
+	usernames     map[string]int64
+	teams         map[string]Team
+	codes         map[string]DozorCode
+	rooms         map[string]string
+	puzzleSets    map[string]PuzzleSet
+	answers       map[string]map[string]PuzzleAnswer
+	game          *Game
+	migrations    map[string]bool
+	conversations map[int64]Conversation
+	updates       map[int]processedUpdate
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		users:         make(map[int64]UserProfile),
+		usernames:     make(map[string]int64),
+		teams:         make(map[string]Team),
+		codes:         make(map[string]DozorCode),
+		rooms:         make(map[string]string),
+		puzzleSets:    make(map[string]PuzzleSet),
+		answers:       make(map[string]map[string]PuzzleAnswer),
+		migrations:    make(map[string]bool),
+		conversations: make(map[int64]Conversation),
+		updates:       make(map[int]processedUpdate),
+	}
+}
+
+func (s *memoryStore) GetUser(fromID int64) (*UserProfile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[fromID]
+	if !ok {
+		return nil, nil
+	}
+	return &user, nil
+}
+
+func (s *memoryStore) GetUsers(fromIDs []int64) (map[int64]*UserProfile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users := make(map[int64]*UserProfile, len(fromIDs))
+	for _, fromID := range fromIDs {
+		if user, ok := s.users[fromID]; ok {
+			users[fromID] = &user
+		}
+	}
+	return users, nil
+}
+
+func (s *memoryStore) updateUser(fromID int64, update func(user *UserProfile)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user := s.users[fromID]
+	user.FromID = fromID
+	update(&user)
+	s.users[fromID] = user
+	return nil
+}
+
+func (s *memoryStore) SetUsername(fromID int64, username string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := usernameKey(username)
+	if owner, ok := s.usernames[key]; ok && owner != fromID {
+		return false, nil
+	}
+
+	user := s.users[fromID]
+	if user.Username != "" {
+		delete(s.usernames, usernameKey(user.Username))
+	}
+	s.usernames[key] = fromID
+	user.FromID = fromID
+	user.Username = username
+	s.users[fromID] = user
+	return true, nil
+}
+
+func (s *memoryStore) FindUsername(username string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.usernames[usernameKey(username)], nil
+}
+
+func (s *memoryStore) SetTeam(fromID int64, team string) error {
+	return s.updateUser(fromID, func(user *UserProfile) { user.Team = team })
+}
+
+func (s *memoryStore) SetAdmin(fromID int64, enabled bool) error {
+	return s.updateUser(fromID, func(user *UserProfile) { user.Admin = enabled })
+}
+
+func (s *memoryStore) ListUsers() ([]*UserProfile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users := make([]*UserProfile, 0, len(s.users))
+	for _, user := range s.users {
+		user := user
+		users = append(users, &user)
+	}
+	return users, nil
+}
+
+func (s *memoryStore) GetTeam(name string) (*Team, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	team, ok := s.teams[name]
+	if !ok {
+		return nil, nil
+	}
+	return &team, nil
+}
+
+func (s *memoryStore) ListTeams() ([]*Team, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	teams := make([]*Team, 0, len(s.teams))
+	for _, team := range s.teams {
+		team := team
+		teams = append(teams, &team)
+	}
+	return teams, nil
+}
+
+func (s *memoryStore) PutTeam(team *Team) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.teams[team.Name] = *team
+	return nil
+}
+
+func (s *memoryStore) DeleteTeam(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.teams, name)
+	return nil
+}
+
+func (s *memoryStore) GetCode(code string) (*DozorCode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dozorCode, ok := s.codes[code]
+	if !ok {
+		return nil, nil
+	}
+	return &dozorCode, nil
+}
+
+func (s *memoryStore) ListCodes() ([]*DozorCode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	codes := make([]*DozorCode, 0, len(s.codes))
+	for _, dozorCode := range s.codes {
+		dozorCode := dozorCode
+		codes = append(codes, &dozorCode)
+	}
+	return codes, nil
+}
+
+func (s *memoryStore) PutCode(code *DozorCode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.codes[code.Code] = DozorCode{
+		Code:   code.Code,
+		Room:   code.Room,
+		Note:   code.Note,
+		Points: code.Points,
+	}
+	return nil
+}
+
+func (s *memoryStore) ClaimCode(code string, fromID int64, foundAt int64) (*DozorCode, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dozorCode, ok := s.codes[code]
+	if !ok {
+		return nil, false, nil
+	}
+	if dozorCode.FromID != 0 {
+		return &dozorCode, false, nil
+	}
+	dozorCode.FromID = fromID
+	dozorCode.FoundAt = foundAt
+	s.codes[code] = dozorCode
+	return &dozorCode, true, nil
+}
+
+func (s *memoryStore) OpenRoom(room string, code string, bonus int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dozorCode, ok := s.codes[code]
+	if !ok {
+		return false, fmt.Errorf("code %s does not exist", code)
+	}
+	if _, opened := s.rooms[room]; opened {
+		return false, nil
+	}
+	s.rooms[room] = code
+	dozorCode.Bonus = bonus
+	s.codes[code] = dozorCode
+	return true, nil
+}
+
+func (s *memoryStore) DeleteCode(code string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// the room can be opened again when its opener is removed
+	if dozorCode, ok := s.codes[code]; ok && s.rooms[dozorCode.Room] == code {
+		delete(s.rooms, dozorCode.Room)
+	}
+	delete(s.codes, code)
+	return nil
+}
+
+func (s *memoryStore) GetPuzzleSet(name string) (*PuzzleSet, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	set, ok := s.puzzleSets[name]
+	if !ok {
+		return nil, nil
+	}
+	return &set, nil
+}
+
+func (s *memoryStore) ListPuzzleSets() ([]*PuzzleSet, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sets := make([]*PuzzleSet, 0, len(s.puzzleSets))
+	for _, set := range s.puzzleSets {
+		set := set
+		sets = append(sets, &set)
+	}
+	return sets, nil
+}
+
+func (s *memoryStore) PutPuzzleSet(set *PuzzleSet) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.puzzleSets[set.Name] = *set
+	return nil
+}
+
+func (s *memoryStore) FindAnswer(set string, answer string) (*PuzzleAnswer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	puzzleAnswer, ok := s.answers[set][answer]
+	if !ok {
+		return nil, nil
+	}
+	return &puzzleAnswer, nil
+}
+
+func (s *memoryStore) ListAnswers(set string) ([]*PuzzleAnswer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	answers := make([]*PuzzleAnswer, 0, len(s.answers[set]))
+	for _, puzzleAnswer := range s.answers[set] {
+		puzzleAnswer := puzzleAnswer
+		answers = append(answers, &puzzleAnswer)
+	}
+	return answers, nil
+}
+
+func (s *memoryStore) PutAnswer(set string, answer string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.answers[set] == nil {
+		s.answers[set] = make(map[string]PuzzleAnswer)
+	}
+	s.answers[set][answer] = PuzzleAnswer{Set: set, Answer: answer}
+	return nil
+}
+
+func (s *memoryStore) AddAlias(set string, answer string, alias string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	puzzleAnswer, ok := s.answers[set][answer]
+	if !ok {
+		return fmt.Errorf("answer %s of %s does not exist", answer, set)
+	}
+	for _, existing := range puzzleAnswer.Aliases {
+		if existing == alias {
+			return nil
+		}
+	}
+	// copy the aliases, the old slice may be shared with returned answers
+	puzzleAnswer.Aliases = append(append([]string{}, puzzleAnswer.Aliases...), alias)
+	s.answers[set][answer] = puzzleAnswer
+	return nil
+}
+
+func (s *memoryStore) ClaimAnswer(set string, answer string, fromID int64, foundAt int64) (*PuzzleAnswer, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	puzzleAnswer, ok := s.answers[set][answer]
+	if !ok {
+		return nil, false, nil
+	}
+	if puzzleAnswer.FromID != 0 {
+		return &puzzleAnswer, false, nil
+	}
+	puzzleAnswer.FromID = fromID
+	puzzleAnswer.FoundAt = foundAt
+	s.answers[set][answer] = puzzleAnswer
+	return &puzzleAnswer, true, nil
+}
+
+func (s *memoryStore) GetGame() (*Game, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.game == nil {
+		return nil, nil
+	}
+	game := *s.game
+	return &game, nil
+}
+
+func (s *memoryStore) PutGame(game *Game) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := *game
+	s.game = &stored
+	return nil
+}
+
+func (s *memoryStore) AnnounceGame(announcedAt int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.game == nil || s.game.AnnouncedAt != 0 {
+		return false, nil
+	}
+	s.game.AnnouncedAt = announcedAt
+	return true, nil
+}
+
+func (s *memoryStore) Migrated(name string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.migrations[name], nil
+}
+
+func (s *memoryStore) MarkMigrated(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.migrations[name] = true
+	return nil
+}
+
+func (s *memoryStore) MarkStandingsSent(fromID int64, gameStart int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[fromID]
+	if !ok || user.StandingsSent == gameStart {
+		return false, nil
+	}
+	user.StandingsSent = gameStart
+	s.users[fromID] = user
+	return true, nil
+}
+
+func (s *memoryStore) GetConversation(fromID int64) (*Conversation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conversation, ok := s.conversations[fromID]
+	if !ok {
+		return nil, nil
+	}
+	conversation.Fields = copyFields(conversation.Fields)
+	return &conversation, nil
+}
+
+func (s *memoryStore) PutConversation(conversation *Conversation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := *conversation
+	stored.Fields = copyFields(conversation.Fields)
+	s.conversations[conversation.FromID] = stored
+	return nil
+}
+
+func (s *memoryStore) DeleteConversation(fromID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.conversations, fromID)
+	return nil
+}
+
+func copyFields(fields map[string]string) map[string]string {
+	copied := make(map[string]string, len(fields))
+	for name, value := range fields {
+		copied[name] = value
+	}
+	return copied
+}
+
+// processedUpdate is an entry of the processed-update ledger
+type processedUpdate struct {
+	expiresAt time.Time
+	leased    bool
+}
+
+func (s *memoryStore) LeaseUpdate(updateID int, startedAt time.Time, lease time.Duration) (UpdateStatus, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry, ok := s.updates[updateID]; ok && !entry.expiresAt.Before(startedAt) {
+		if entry.leased {
+			return UpdateInProgress, nil
+		}
+		return UpdateProcessed, nil
+	}
+	s.updates[updateID] = processedUpdate{expiresAt: startedAt.Add(lease), leased: true}
+	return UpdateLeased, nil
+}
+
+func (s *memoryStore) MarkUpdateProcessed(updateID int, processedAt time.Time, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.updates[updateID] = processedUpdate{expiresAt: processedAt.Add(ttl)}
+	return nil
+}
+
+func (s *memoryStore) UnmarkUpdateProcessed(updateID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.updates, updateID)
+	return nil
+}
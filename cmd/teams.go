@@ -0,0 +1,367 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// maxTeamNameLength keeps "team:<name>" within the 64 bytes Telegram allows
+// for the data of an inline button
+const maxTeamNameLength = 32
+
+// label is how the team is shown to players, e.g. "🐺 Wolves"
+func (t *Team) label() string {
+	label := t.Name
+	if t.DisplayName != "" {
+		label = t.DisplayName
+	}
+	if t.Emoji != "" {
+		label = t.Emoji + " " + label
+	}
+	return label
+}
+
+// teamLabel returns the label of the team with the given name, or the name
+// itself when the team does not exist anymore
+func teamLabel(teams []*Team, name string) string {
+	for _, team := range teams {
+		if team.Name == name {
+			return team.label()
+		}
+	}
+	return name
+}
+
+// sortedTeams returns all teams ordered by name
+func sortedTeams(store Store) ([]*Team, error) {
+	teams, err := store.ListTeams()
+	if err != nil {
+		log.Printf("failed to list teams: %v\n", err)
+		return nil, err
+	}
+	sort.Slice(teams, func(i, j int) bool {
+		return teams[i].Name < teams[j].Name
+	})
+	return teams, nil
+}
+
+// seedTeams adds the teams of the config to an empty Team table, so that
+// players who joined one of the teams built into earlier versions keep it.
+// It runs once: teams removed by admins later do not come back.
+func seedTeams(store Store, names []string) error {
+	migrated, err := store.Migrated("teams")
+	if err != nil || migrated {
+		return err
+	}
+
+	teams, err := store.ListTeams()
+	if err != nil {
+		log.Printf("failed to list teams: %v\n", err)
+		return err
+	}
+	if len(teams) == 0 {
+		for _, name := range names {
+			if err := store.PutTeam(&Team{Name: name}); err != nil {
+				log.Printf("failed to put team: %v\n", err)
+				return err
+			}
+		}
+	}
+	return store.MarkMigrated("teams")
+}
+
+func validateTeam(a *App, name string) (string, error) {
+	team, err := a.store.GetTeam(name)
+	if err != nil {
+		log.Printf("failed to get team: %v\n", err)
+		return "", err
+	}
+	if team != nil {
+		return "", nil
+	}
+
+	teams, err := sortedTeams(a.store)
+	if err != nil {
+		return "", err
+	}
+	if len(teams) == 0 {
+		return "No teams were added yet", nil
+	}
+	validTeams := ""
+	for _, valid := range teams {
+		validTeams += "'" + valid.Name + "' "
+	}
+	return "Please provide a valid team. Valid teams are " + validTeams, nil
+}
+
+// validateNewTeam checks the name of a team that is about to be created
+func validateNewTeam(a *App, name string) (string, error) {
+	if len(name) > maxTeamNameLength {
+		return "Team name is too long, the limit is " + strconv.Itoa(maxTeamNameLength) + " characters", nil
+	}
+	if strings.ContainsAny(name, " \t\n") {
+		return "Team name cannot contain spaces", nil
+	}
+
+	team, err := a.store.GetTeam(name)
+	if err != nil {
+		log.Printf("failed to get team: %v\n", err)
+		return "", err
+	}
+	if team != nil {
+		return "Team " + name + " already exists", nil
+	}
+	return "", nil
+}
+
+func teamKeyboard(a *App) interface{} {
+	teams, err := sortedTeams(a.store)
+	if err != nil || len(teams) == 0 {
+		return nil
+	}
+
+	// two teams per row
+	rows := [][]tgbotapi.InlineKeyboardButton{}
+	for i, team := range teams {
+		if i%2 == 0 {
+			rows = append(rows, []tgbotapi.InlineKeyboardButton{})
+		}
+		rows[len(rows)-1] = append(rows[len(rows)-1], tgbotapi.NewInlineKeyboardButtonData(team.label(), callbackData("team", team.Name)))
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// joinTeam makes the user a member of the named team and welcomes them.
+// It reports whether the team exists.
+func joinTeam(a *App, req *Request, name string) (bool, error) {
+	team, err := a.store.GetTeam(name)
+	if err != nil {
+		log.Printf("failed to get team: %v\n", err)
+		return false, err
+	}
+	if team == nil {
+		a.notify(req, "Team "+name+" does not exist")
+		return false, nil
+	}
+
+	// update the user item with the team
+	if err := a.store.SetTeam(req.FromID, team.Name); err != nil {
+		log.Printf("failed to set team: %v\n", err)
+		return false, err
+	}
+
+	switch {
+	case req.CallbackID == "":
+		msg := tgbotapi.NewMessage(req.ChatID, "Welcome to team "+team.label()+"!")
+		msg.ReplyMarkup = tgbotapi.NewRemoveKeyboard(true)
+		a.bot.Send(msg)
+	case req.ChatType == "private":
+		a.editMessage(req, "Welcome to team "+team.label()+"!", nil)
+		a.answerCallback(req, "")
+	default:
+		// keep the buttons for the other members of the group
+		msg := tgbotapi.NewMessage(req.ChatID, "Welcome to team "+team.label()+", "+req.User.Username+"!")
+		a.bot.Send(msg)
+		a.answerCallback(req, "")
+	}
+	return true, nil
+}
+
+func registerTeam(a *App, req *Request) error {
+	_, err := joinTeam(a, req, req.Fields["team"])
+	return err
+}
+
+// chooseTeam handles the team buttons sent with the /team prompt
+func chooseTeam(a *App, req *Request) error {
+	joined, err := joinTeam(a, req, req.Arg)
+	if err != nil || !joined {
+		return err
+	}
+
+	// the button answers the /team dialog
+	conversation, err := a.store.GetConversation(req.FromID)
+	if err != nil {
+		return err
+	}
+	if conversation != nil && conversation.Command == req.Command.Name {
+		return a.store.DeleteConversation(req.FromID)
+	}
+	return nil
+}
+
+// teamMembers returns the users who joined the team
+func teamMembers(store Store, name string) ([]*UserProfile, error) {
+	users, err := store.ListUsers()
+	if err != nil {
+		log.Printf("failed to list users: %v\n", err)
+		return nil, err
+	}
+
+	members := []*UserProfile{}
+	for _, user := range users {
+		if user.Team == name {
+			members = append(members, user)
+		}
+	}
+	return members, nil
+}
+
+func listTeams(a *App, req *Request) error {
+	teams, err := sortedTeams(a.store)
+	if err != nil {
+		return err
+	}
+	if len(teams) == 0 {
+		msg := tgbotapi.NewMessage(req.ChatID, "No teams were added yet")
+		a.bot.Send(msg)
+		return nil
+	}
+
+	users, err := a.store.ListUsers()
+	if err != nil {
+		log.Printf("failed to list users: %v\n", err)
+		return err
+	}
+	membersByTeam := make(map[string]int)
+	for _, user := range users {
+		membersByTeam[user.Team]++
+	}
+
+	messageString := "Teams:\n"
+	for _, team := range teams {
+		messageString += team.label()
+		if team.label() != team.Name {
+			messageString += " (" + team.Name + ")"
+		}
+		messageString += ": " + strconv.Itoa(membersByTeam[team.Name]) + " players\n"
+	}
+
+	msg := tgbotapi.NewMessage(req.ChatID, messageString)
+	a.bot.Send(msg)
+	return nil
+}
+
+func addTeam(a *App, req *Request) error {
+	team := &Team{
+		Name:        req.Fields["name"],
+		DisplayName: req.Fields["display_name"],
+		Emoji:       req.Fields["emoji"],
+	}
+	if err := a.store.PutTeam(team); err != nil {
+		log.Printf("failed to put team: %v\n", err)
+		return err
+	}
+
+	msg := tgbotapi.NewMessage(req.ChatID, "Team "+team.label()+" was added")
+	a.bot.Send(msg)
+	return nil
+}
+
+func renameTeam(a *App, req *Request) error {
+	oldName, newName := req.Fields["team"], req.Fields["name"]
+	team, err := a.store.GetTeam(oldName)
+	if err != nil {
+		log.Printf("failed to get team: %v\n", err)
+		return err
+	}
+	if team == nil {
+		msg := tgbotapi.NewMessage(req.ChatID, "Team "+oldName+" does not exist")
+		a.bot.Send(msg)
+		return nil
+	}
+
+	members, err := teamMembers(a.store, oldName)
+	if err != nil {
+		return err
+	}
+
+	// the team exists under the new name before its members move, so no
+	// player is left in a team that does not exist
+	team.Name = newName
+	if err := a.store.PutTeam(team); err != nil {
+		log.Printf("failed to put team: %v\n", err)
+		return err
+	}
+	for _, member := range members {
+		if err := a.store.SetTeam(member.FromID, newName); err != nil {
+			log.Printf("failed to set team: %v\n", err)
+			return err
+		}
+	}
+	if err := a.store.DeleteTeam(oldName); err != nil {
+		log.Printf("failed to delete team: %v\n", err)
+		return err
+	}
+
+	msg := tgbotapi.NewMessage(req.ChatID, "Team "+oldName+" was renamed to "+newName+", "+strconv.Itoa(len(members))+" players moved")
+	a.bot.Send(msg)
+	return nil
+}
+
+func removeTeam(a *App, req *Request) error {
+	name := req.Fields["team"]
+	members, err := teamMembers(a.store, name)
+	if err != nil {
+		return err
+	}
+	if len(members) > 0 {
+		msg := tgbotapi.NewMessage(req.ChatID, "Team "+name+" still has "+strconv.Itoa(len(members))+" players")
+		a.bot.Send(msg)
+		return nil
+	}
+
+	if err := a.store.DeleteTeam(name); err != nil {
+		log.Printf("failed to delete team: %v\n", err)
+		return err
+	}
+
+	msg := tgbotapi.NewMessage(req.ChatID, "Team "+name+" was removed")
+	a.bot.Send(msg)
+	return nil
+}
+
+// updateTeam changes the team from the "team" field and reports the result
+func updateTeam(a *App, req *Request, update func(team *Team)) error {
+	team, err := a.store.GetTeam(req.Fields["team"])
+	if err != nil {
+		log.Printf("failed to get team: %v\n", err)
+		return err
+	}
+	if team == nil {
+		msg := tgbotapi.NewMessage(req.ChatID, "Team "+req.Fields["team"]+" does not exist")
+		a.bot.Send(msg)
+		return nil
+	}
+
+	update(team)
+	if err := a.store.PutTeam(team); err != nil {
+		log.Printf("failed to put team: %v\n", err)
+		return err
+	}
+
+	msg := tgbotapi.NewMessage(req.ChatID, "Team "+team.Name+" is now shown as "+team.label())
+	a.bot.Send(msg)
+	return nil
+}
+
+func setTeamDisplayName(a *App, req *Request) error {
+	return updateTeam(a, req, func(team *Team) { team.DisplayName = req.Fields["display_name"] })
+}
+
+func setTeamEmoji(a *App, req *Request) error {
+	return updateTeam(a, req, func(team *Team) { team.Emoji = req.Fields["emoji"] })
+}
+
+// validateEmoji accepts a short string, an emoji can take several code points
+func validateEmoji(a *App, emoji string) (string, error) {
+	if utf8.RuneCountInString(emoji) > 8 {
+		return "Please provide a single emoji", nil
+	}
+	return "", nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const secretTokenHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+// maxUpdateSize limits the request body. Updates are a few kilobytes even
+// with long messages, so anything bigger is not from Telegram.
+const maxUpdateSize = 1 << 20
+
+// webhookHandler accepts updates pushed by Telegram. Requests without the
+// secret token passed to setWebhook are rejected, so knowing the URL is not
+// enough to inject updates.
+type webhookHandler struct {
+	app         *App
+	secretToken string
+}
+
+func (h *webhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := r.Header.Get(secretTokenHeader)
+	if subtle.ConstantTimeCompare([]byte(token), []byte(h.secretToken)) != 1 {
+		log.Printf("rejected update from %s: invalid secret token\n", r.RemoteAddr)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var update tgbotapi.Update
+	body := http.MaxBytesReader(w, r.Body, maxUpdateSize)
+	if err := json.NewDecoder(body).Decode(&update); err != nil {
+		log.Printf("failed to decode update: %v\n", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.app.ProcessUpdate(r.Context(), update); err != nil {
+		log.Printf("failed to process update %d: %v\n", update.UpdateID, err)
+		if isRetriable(err) {
+			// Telegram redelivers the update when the response is not 2xx
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func setWebhook(bot *tgbotapi.BotAPI, url string, secretToken string) error {
+	// WebhookConfig has no secret_token field, so the request is built by hand
+	params := tgbotapi.Params{
+		"url":          url,
+		"secret_token": secretToken,
+	}
+	if _, err := bot.MakeRequest("setWebhook", params); err != nil {
+		log.Printf("failed to set webhook: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+// runWebhook serves Telegram webhook requests on addr until ctx is cancelled.
+// When url is not empty the webhook is registered with Telegram first.
+func runWebhook(ctx context.Context, secrets SecretProvider, store Store, config *Config, addr string, url string, secretToken string) error {
+	if secretToken == "" {
+		return errors.New("WEBHOOK_SECRET is not set")
+	}
+
+	bot, err := newBot(secrets)
+	if err != nil {
+		return err
+	}
+
+	setCommandsMenu(bot, newCommands())
+
+	if url != "" {
+		if err := setWebhook(bot, url, secretToken); err != nil {
+			return err
+		}
+	}
+
+	server := &http.Server{
+		Addr: addr,
+		Handler: &webhookHandler{
+			app:         newApp(bot, store, config, time.Now),
+			secretToken: secretToken,
+		},
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("failed to shut down server: %v\n", err)
+		}
+	}()
+
+	log.Printf("listening for webhook updates on %s\n", addr)
+
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
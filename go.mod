@@ -4,10 +4,13 @@ go 1.20
 
 require github.com/aws/aws-lambda-go v1.41.0
 
-require github.com/go-telegram-bot-api/telegram-bot-api/v5 v5.5.1
+require (
+	github.com/aws/aws-sdk-go v1.48.1
+	github.com/go-telegram-bot-api/telegram-bot-api/v5 v5.5.1
+	golang.org/x/text v0.14.0
+)
 
 require (
-	github.com/aws/aws-sdk-go v1.48.1 // indirect
 	github.com/google/uuid v1.4.0 // indirect
 	github.com/jmespath/go-jmespath v0.4.0 // indirect
 )
@@ -15,17 +15,26 @@ resource "aws_iam_policy" "additional_policy" {
       "Effect": "Allow",
       "Action": [
         "dynamodb:GetItem",
+        "dynamodb:BatchGetItem",
         "dynamodb:PutItem",
         "dynamodb:UpdateItem",
         "dynamodb:DeleteItem",
+        "dynamodb:Query",
         "dynamodb:Scan"
       ],
       "Resource": [
         "arn:aws:dynamodb:eu-central-1:680324637652:table/UserProfile",
+        "arn:aws:dynamodb:eu-central-1:680324637652:table/Username",
+        "arn:aws:dynamodb:eu-central-1:680324637652:table/Team",
         "arn:aws:dynamodb:eu-central-1:680324637652:table/DozorCode",
+        "arn:aws:dynamodb:eu-central-1:680324637652:table/Room",
         "arn:aws:dynamodb:eu-central-1:680324637652:table/WaitingCommand",
+        "arn:aws:dynamodb:eu-central-1:680324637652:table/PuzzleSet",
+        "arn:aws:dynamodb:eu-central-1:680324637652:table/PuzzleAnswer",
         "arn:aws:dynamodb:eu-central-1:680324637652:table/PairA",
-        "arn:aws:dynamodb:eu-central-1:680324637652:table/PairB"
+        "arn:aws:dynamodb:eu-central-1:680324637652:table/PairB",
+        "arn:aws:dynamodb:eu-central-1:680324637652:table/Game",
+        "arn:aws:dynamodb:eu-central-1:680324637652:table/ProcessedUpdate"
       ]
     }
   ]
@@ -58,4 +67,6 @@ resource "aws_lambda_event_source_mapping" "event_mapping" {
   function_name     = aws_lambda_function.lambda.function_name
   enabled           = true
   starting_position = "LATEST"
+
+  function_response_types = ["ReportBatchItemFailures"]
 }